@@ -45,7 +45,7 @@ func (c *OneMlClient) GetNodeInfo(pubkey string) (OneML_NodeInfoResponse, error)
 	log.Infof("Getting info from 1ml.com for %s", pubkey)
 
 	client := http.Client{
-		Timeout: time.Second * time.Duration(config.Configuration.ApiRules.OneMl.Timeout),
+		Timeout: time.Second * time.Duration(config.Current().ApiRules.OneMl.Timeout),
 	}
 
 	req, err := http.NewRequest(http.MethodGet, url, nil)
@@ -155,7 +155,7 @@ func (c *AmbossClient) GetNodeInfo(pubkey string) (Amboss_NodeInfoResponse, erro
 	graphqlRequest.Header.Set("Content-Type", "application/json")
 
 	var r_nested Amboss_NodeInfoResponse_Nested
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(config.Configuration.ApiRules.Amboss.Timeout))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(config.Current().ApiRules.Amboss.Timeout))
 	defer cancel()
 
 	if err := graphqlClient.Run(ctx, graphqlRequest, &r_nested.Data); err != nil {
@@ -20,7 +20,7 @@ func GetApiNodeinfo(pubkey string) (ApiNodeInfo, error) {
 		Amboss: Amboss_NodeInfoResponse{},
 	}
 
-	if config.Configuration.ApiRules.OneMl.Active {
+	if config.Current().ApiRules.OneMl.Active {
 		// get info from 1ml
 		OnemlClient := GetOneMlClient()
 		onemlNodeInfo, err := OnemlClient.GetNodeInfo(pubkey)
@@ -31,7 +31,7 @@ func GetApiNodeinfo(pubkey string) (ApiNodeInfo, error) {
 		response.OneMl = onemlNodeInfo
 	}
 
-	if config.Configuration.ApiRules.Amboss.Active {
+	if config.Current().ApiRules.Amboss.Active {
 		// get info from amboss
 		ambossClient := GetAmbossClient()
 		ambossNodeInfo, err := ambossClient.GetNodeInfo(pubkey)
@@ -4,6 +4,7 @@ import (
 	"os"
 
 	"github.com/callebtc/electronwall/api"
+	"github.com/callebtc/electronwall/config"
 	"github.com/callebtc/electronwall/rules"
 	"github.com/callebtc/electronwall/types"
 	"github.com/lightningnetwork/lnd/lnrpc"
@@ -15,6 +16,9 @@ func main() {
 		log.Errorf("Pass node ID as argument.")
 		return
 	}
+	if err := config.Init("config.yaml"); err != nil {
+		log.Fatalf("Could not load config: %v", err)
+	}
 	pubkey := os.Args[1]
 	log.Infof("pubkey: %s", pubkey)
 	pk_byte := []byte(pubkey)
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/callebtc/electronwall/config"
+	"github.com/callebtc/electronwall/types"
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+// channelCounts counts the open and pending channels with the peer with the
+// hex encoded pubkey and the pending channels that any peers opened
+func (app *App) channelCounts(ctx context.Context, pubkey string) (*types.ChannelCounts, error) {
+	channels, err := app.lnd.listChannels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := app.lnd.pendingOpenChannels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counts := &types.ChannelCounts{}
+	for _, ch := range channels {
+		if ch.RemotePubkey == pubkey {
+			counts.Open++
+			counts.OpenCapacity += ch.Capacity
+		}
+	}
+	for _, p := range pending {
+		ch := p.Channel
+		if ch == nil {
+			continue
+		}
+		if ch.RemoteNodePub == pubkey {
+			counts.Pending++
+			counts.PendingCapacity += ch.Capacity
+		}
+		if ch.Initiator == lnrpc.Initiator_INITIATOR_REMOTE {
+			counts.PendingInbound++
+		}
+	}
+	return counts, nil
+}
+
+// checkChannelCaps returns a reason for every channel cap that a channel open
+// request exceeds. The requested channel counts toward the caps. If the
+// channels could not be counted, caps that are set reject the request.
+func checkChannelCaps(conf *config.Config, req *lnrpc.ChannelAcceptRequest, counts *types.ChannelCounts) []string {
+	cc := conf.ChannelCaps(hex.EncodeToString(req.NodePubkey))
+	if counts == nil {
+		if cc.Enforced() {
+			return []string{"channels could not be counted"}
+		}
+		return nil
+	}
+	var reasons []string
+	channels := counts.Open + counts.Pending + 1
+	if cc.MaxChannels != nil && channels > int(*cc.MaxChannels) {
+		reasons = append(reasons, fmt.Sprintf("%d channels with the peer, more than %d", channels, *cc.MaxChannels))
+	}
+	capacity := uint64(counts.OpenCapacity+counts.PendingCapacity) + req.FundingAmt
+	if cc.MaxCapacitySat != nil && capacity > *cc.MaxCapacitySat {
+		reasons = append(reasons, fmt.Sprintf("capacity of %d sat with the peer above %d sat", capacity, *cc.MaxCapacitySat))
+	}
+	pendingInbound := counts.PendingInbound + 1
+	if cc.MaxPendingInbound != nil && pendingInbound > int(*cc.MaxPendingInbound) {
+		reasons = append(reasons, fmt.Sprintf("%d pending inbound channels, more than %d", pendingInbound, *cc.MaxPendingInbound))
+	}
+	return reasons
+}
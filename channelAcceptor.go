@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/hex"
 	"fmt"
+	"strings"
 	"sync"
 
 	"github.com/callebtc/electronwall/api"
@@ -14,7 +15,7 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func (app *App) GetChannelAcceptEvent(ctx context.Context, req lnrpc.ChannelAcceptRequest) (types.ChannelAcceptEvent, error) {
+func (app *App) GetChannelAcceptEvent(ctx context.Context, req *lnrpc.ChannelAcceptRequest) (types.ChannelAcceptEvent, error) {
 	// print the incoming channel request
 	alias, err := app.lnd.getNodeAlias(ctx, hex.EncodeToString(req.NodePubkey))
 	if err != nil {
@@ -31,13 +32,21 @@ func (app *App) GetChannelAcceptEvent(ctx context.Context, req lnrpc.ChannelAcce
 		log.Errorf(err.Error())
 	}
 
+	counts, err := app.channelCounts(ctx, hex.EncodeToString(req.NodePubkey))
+	if err != nil {
+		log.Errorf(err.Error())
+	}
+
 	return types.ChannelAcceptEvent{
-		PubkeyFrom: hex.EncodeToString(req.NodePubkey),
-		AliasFrom:  alias,
-		NodeInfo:   info,
-		Event:      &req,
-		OneMl:      noeInfo.OneMl,
-		Amboss:     noeInfo.Amboss,
+		PubkeyFrom:     hex.EncodeToString(req.NodePubkey),
+		AliasFrom:      alias,
+		NodeInfo:       info,
+		Event:          req,
+		OneMl:          noeInfo.OneMl,
+		Amboss:         noeInfo.Amboss,
+		CommitmentType: req.CommitmentType.String(),
+		WantsZeroConf:  req.WantsZeroConf,
+		Counts:         counts,
 	}, nil
 }
 
@@ -72,16 +81,29 @@ func (app *App) interceptChannelEvents(ctx context.Context) error {
 		panic(err)
 	}
 	for {
-		req := lnrpc.ChannelAcceptRequest{}
-		err = acceptClient.RecvMsg(&req)
+		req := &lnrpc.ChannelAcceptRequest{}
+		err = acceptClient.RecvMsg(req)
 		if err != nil {
 			return err
 		}
+		// use the same configuration for the whole decision
+		conf := config.Current()
+
+		// rate limited requests are answered before any lookups
+		if reason, ok := app.openLimiter.allow(conf.ChannelRateLimit, hex.EncodeToString(req.NodePubkey)); !ok {
+			res := app.rateLimitedChannelResponse(conf, req, reason)
+			err = acceptClient.Send(&res)
+			if err != nil {
+				log.Errorf(err.Error())
+			}
+			continue
+		}
 
 		channelAcceptEvent, err := app.GetChannelAcceptEvent(ctx, req)
 		if err != nil {
 			return err
 		}
+		channelAcceptEvent.Params = channelParams(conf, req)
 
 		var node_info_string string
 		if channelAcceptEvent.AliasFrom != "" {
@@ -117,49 +139,81 @@ func (app *App) interceptChannelEvents(ctx context.Context) error {
 			"pending_chan_id": hex.EncodeToString(channelAcceptEvent.Event.PendingChanId),
 			"total_capacity":  channelAcceptEvent.NodeInfo.TotalCapacity,
 			"num_channels":    channelAcceptEvent.NodeInfo.NumChannels,
+			"commitment_type": channelAcceptEvent.CommitmentType,
+			"zero_conf":       channelAcceptEvent.WantsZeroConf,
 		})
 
 		// make decision
-		decision_chan := make(chan bool, 1)
-		rules_decision, err := rules.Apply(channelAcceptEvent, decision_chan)
-		if err != nil {
-			return err
+		rules_decision := rules.NoDecision
+		if conf.ChannelPolicy != config.PolicyListOnly {
+			rules_decision, err = rules.Evaluate(ctx, conf, channelAcceptEvent)
+			if err != nil {
+				return err
+			}
 		}
 		// parse list
-		list_decision, err := app.channelAcceptListDecision(req)
+		list_decision, list_reason, err := app.channelAcceptListDecision(conf, req)
 		if err != nil {
 			return err
 		}
+		// the rule may have changed the parameters
+		for _, invalid := range validateChannelParams(channelAcceptEvent.Params, req, conf.Network) {
+			log.Warnf("[channel] Ignoring channel parameter: %s", invalid)
+		}
 
-		accept := true
-		if !rules_decision || !list_decision {
-			accept = false
+		accept := combineDecisions(conf.ChannelPolicy, list_decision, rules_decision)
+
+		decision_info_string := fmt.Sprintf("[policy %s: list %s (%s), rules %s]", conf.ChannelPolicy, listResultString(list_decision), list_reason, rules_decision)
+		contextLogger = contextLogger.WithFields(log.Fields{
+			"policy":         conf.ChannelPolicy,
+			"list_decision":  listResultString(list_decision),
+			"list_reason":    list_reason,
+			"rules_decision": rules_decision.String(),
+		})
+
+		// an accepted channel still has to meet the constraints and the
+		// caps, and the peer is told which ones it violates
+		reason, message := "", conf.ChannelRejectMessage
+		if accept {
+			reasons := checkChannelConstraints(conf, req)
+			reasons = append(reasons, checkChannelCaps(conf, req, channelAcceptEvent.Counts)...)
+			if len(reasons) > 0 {
+				accept = false
+				reason = "constraints: " + strings.Join(reasons, "; ")
+				message = rejectMessage(reasons)
+				decision_info_string += " [" + reason + "]"
+				contextLogger = contextLogger.WithField("constraints", reasons)
+			}
+		}
+		if !accept && reason == "" {
+			reason = denyReason(conf.ChannelPolicy, list_decision, list_reason, rules_decision)
 		}
 
 		res := lnrpc.ChannelAcceptResponse{}
-		if accept {
-			if config.Configuration.LogJson {
+		switch {
+		case accept:
+			if conf.LogJson {
 				contextLogger.Infof("allow")
 			} else {
-				log.Infof("[channel] ✅ Allow channel %s", channel_info_string)
+				log.Infof("[channel] ✅ Allow channel %s %s", channel_info_string, decision_info_string)
 			}
-			res = lnrpc.ChannelAcceptResponse{Accept: true,
-				PendingChanId:   req.PendingChanId,
-				CsvDelay:        req.CsvDelay,
-				MaxHtlcCount:    req.MaxAcceptedHtlcs,
-				ReserveSat:      req.ChannelReserve,
-				InFlightMaxMsat: req.MaxValueInFlight,
-				MinHtlcIn:       req.MinHtlc,
+			res = channelAcceptResponse(req, channelAcceptEvent.Params, grantsZeroConf(conf, req))
+		case conf.ChannelMonitor:
+			if conf.LogJson {
+				contextLogger.WithField("reason", reason).Infof("would deny")
+			} else {
+				log.Infof("[channel] 👀 Would deny channel %s %s", channel_info_string, decision_info_string)
 			}
-
-		} else {
-			if config.Configuration.LogJson {
-				contextLogger.Infof("deny")
+			app.monitor.recordChannel(reason)
+			res = monitorChannelResponse(req, channelAcceptEvent.Params)
+		default:
+			if conf.LogJson {
+				contextLogger.WithField("reason", reason).Infof("deny")
 			} else {
-				log.Infof("[channel] ❌ Deny channel %s", channel_info_string)
+				log.Infof("[channel] ❌ Deny channel %s %s", channel_info_string, decision_info_string)
 			}
 			res = lnrpc.ChannelAcceptResponse{Accept: false,
-				Error: config.Configuration.ChannelRejectMessage}
+				Error: message}
 		}
 		err = acceptClient.Send(&res)
 		if err != nil {
@@ -169,27 +223,83 @@ func (app *App) interceptChannelEvents(ctx context.Context) error {
 
 }
 
-func (app *App) channelAcceptListDecision(req lnrpc.ChannelAcceptRequest) (bool, error) {
-	// determine mode and list of channels to parse
-	var accept bool
-	var listToParse []string
-	if config.Configuration.ChannelMode == "allowlist" {
-		accept = false
-		listToParse = config.Configuration.ChannelAllowlist
-	} else if config.Configuration.ChannelMode == "denylist" {
-		accept = true
-		listToParse = config.Configuration.ChannelDenylist
+// rateLimitedChannelResponse rejects a rate limited channel open request, or
+// accepts it in monitor mode. The requests are logged at debug level because
+// a peer that exceeds the limits usually keeps retrying.
+func (app *App) rateLimitedChannelResponse(conf *config.Config, req *lnrpc.ChannelAcceptRequest, reason string) lnrpc.ChannelAcceptResponse {
+	channel_info_string := fmt.Sprintf("(%d sat) from %s", req.FundingAmt, trimPubKey(req.NodePubkey))
+	contextLogger := log.WithFields(log.Fields{
+		"event":           "channel_request",
+		"amount":          req.FundingAmt,
+		"pubkey":          hex.EncodeToString(req.NodePubkey),
+		"pending_chan_id": hex.EncodeToString(req.PendingChanId),
+		"reason":          reason,
+	})
+	if conf.ChannelMonitor {
+		if conf.LogJson {
+			contextLogger.Debugf("would deny")
+		} else {
+			log.Debugf("[channel] 👀 Would deny channel %s [%s]", channel_info_string, reason)
+		}
+		app.monitor.recordChannel(reason)
+		params := channelParams(conf, req)
+		for _, invalid := range validateChannelParams(params, req, conf.Network) {
+			log.Warnf("[channel] Ignoring channel parameter: %s", invalid)
+		}
+		return monitorChannelResponse(req, params)
+	}
+	if conf.LogJson {
+		contextLogger.Debugf("deny")
+	} else {
+		log.Debugf("[channel] ❌ Deny channel %s [%s]", channel_info_string, reason)
+	}
+	return lnrpc.ChannelAcceptResponse{Accept: false, Error: reason}
+}
+
+// channelAcceptResponse accepts the channel with the parameters. A zero-conf
+// channel can be used without confirmations, which needs a MinAcceptDepth of
+// 0. Otherwise, 0 means the default of LND.
+func channelAcceptResponse(req *lnrpc.ChannelAcceptRequest, params *types.ChannelAcceptParams, zeroConf bool) lnrpc.ChannelAcceptResponse {
+	return lnrpc.ChannelAcceptResponse{Accept: true,
+		PendingChanId:   req.PendingChanId,
+		CsvDelay:        params.CsvDelay,
+		MaxHtlcCount:    params.MaxHtlcCount,
+		ReserveSat:      params.ReserveSat,
+		InFlightMaxMsat: params.InFlightMaxMsat,
+		MinHtlcIn:       params.MinHtlcIn,
+		UpfrontShutdown: params.UpfrontShutdown,
+		ZeroConf:        zeroConf,
+		MinAcceptDepth:  0,
+	}
+}
+
+// monitorChannelResponse accepts a channel that monitor mode would have
+// denied. LND fails a zero-conf request that is not granted, so zero-conf is
+// granted whenever the request negotiated it.
+func monitorChannelResponse(req *lnrpc.ChannelAcceptRequest, params *types.ChannelAcceptParams) lnrpc.ChannelAcceptResponse {
+	return channelAcceptResponse(req, params, req.WantsZeroConf)
+}
+
+// channelAcceptListDecision checks the rules of the channel chain in order.
+// The first matching rule decides, otherwise the default of the chain applies.
+// The returned reason names the rule that decided.
+func (app *App) channelAcceptListDecision(conf *config.Config, req *lnrpc.ChannelAcceptRequest) (bool, string, error) {
+	if conf.ChannelMode == "passthrough" {
+		// only reachable if passthrough was enabled by a config reload
+		return true, "passthrough", nil
 	}
 
-	// parse and make decision
-	for _, pubkey := range listToParse {
-		if hex.EncodeToString(req.NodePubkey) == pubkey || pubkey == "*" {
-			accept = !accept
+	accept := conf.ChannelDefaultAccept
+	reason := "default " + listResultString(accept)
+	for i, rule := range conf.ChannelRules {
+		if rule.Peer.Matches(hex.EncodeToString(req.NodePubkey)) {
+			accept = rule.Accept
+			reason = fmt.Sprintf("rule %d: %s", i+1, rule)
 			break
 		}
 	}
-	log.Infof("[list] decision: %t", accept)
-	return accept, nil
+	log.Infof("[list] decision: %t (%s)", accept, reason)
+	return accept, reason, nil
 
 }
 
@@ -215,7 +325,7 @@ func (app *App) logChannelEvents(ctx context.Context) error {
 				alias,
 			)
 
-			if config.Configuration.LogJson {
+			if config.Current().LogJson {
 				contextLogger := log.WithFields(log.Fields{
 					"event":    "channel",
 					"capacity": event.GetOpenChannel().Capacity,
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/callebtc/electronwall/types"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// channelRefreshInterval is how often the channels are refreshed without
+	// any event
+	channelRefreshInterval = time.Minute
+	// channelRefreshDelay is the least time between two refreshes
+	channelRefreshDelay = time.Second
+)
+
+// channelCache holds a snapshot of the open channels of the node. It is
+// refreshed from ListChannels whenever a channel or HTLC event arrives, so
+// that decisions do not need an RPC per HTLC.
+type channelCache struct {
+	mu       sync.RWMutex
+	channels map[uint64]types.ChannelSnapshot
+	updated  time.Time
+	stale    chan struct{}
+}
+
+func newChannelCache() *channelCache {
+	return &channelCache{
+		channels: map[uint64]types.ChannelSnapshot{},
+		stale:    make(chan struct{}, 1),
+	}
+}
+
+// get returns the snapshot of a channel or nil if it is not known
+func (c *channelCache) get(chanID uint64) *types.ChannelSnapshot {
+	snapshot, _ := c.lookup(chanID)
+	return snapshot
+}
+
+// lookup returns the snapshot of a channel or nil if it is not known, and
+// when the snapshot was taken
+func (c *channelCache) lookup(chanID uint64) (*types.ChannelSnapshot, time.Time) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	snapshot, ok := c.channels[chanID]
+	if !ok {
+		return nil, c.updated
+	}
+	return &snapshot, c.updated
+}
+
+// set replaces the snapshot with the channels that were listed at updated
+func (c *channelCache) set(channels []*lnrpc.Channel, updated time.Time) {
+	snapshots := make(map[uint64]types.ChannelSnapshot, len(channels))
+	for _, ch := range channels {
+		snapshots[ch.ChanId] = channelSnapshot(ch)
+	}
+	c.mu.Lock()
+	c.channels = snapshots
+	c.updated = updated
+	c.mu.Unlock()
+}
+
+// invalidate requests a refresh without blocking
+func (c *channelCache) invalidate() {
+	select {
+	case c.stale <- struct{}{}:
+	default:
+	}
+}
+
+func channelSnapshot(ch *lnrpc.Channel) types.ChannelSnapshot {
+	return types.ChannelSnapshot{
+		ChanId:        ch.ChanId,
+		RemotePubkey:  ch.RemotePubkey,
+		Capacity:      ch.Capacity,
+		LocalBalance:  ch.LocalBalance,
+		RemoteBalance: ch.RemoteBalance,
+		PendingHtlcs:  len(ch.PendingHtlcs),
+		// the constraints of a node limit the HTLCs that it offers
+		MaxOutgoingHtlcs: ch.GetLocalConstraints().GetMaxAcceptedHtlcs(),
+		MaxIncomingHtlcs: ch.GetRemoteConstraints().GetMaxAcceptedHtlcs(),
+		Active:           ch.Active,
+		Private:          ch.Private,
+	}
+}
+
+// maxHtlcs returns the negotiated number of HTLCs that a channel carries at
+// once to the peer if outgoing is set and from the peer otherwise, or 0 if it
+// is not known
+func (c *channelCache) maxHtlcs(chanID uint64, outgoing bool) uint32 {
+	snapshot := c.get(chanID)
+	switch {
+	case snapshot == nil:
+		return 0
+	case outgoing:
+		return snapshot.MaxOutgoingHtlcs
+	}
+	return snapshot.MaxIncomingHtlcs
+}
+
+// refreshChannels replaces the channel snapshot with the channels from LND
+func (app *App) refreshChannels(ctx context.Context) error {
+	updated := time.Now()
+	channels, err := app.lnd.listChannels(ctx)
+	if err != nil {
+		return err
+	}
+	app.channels.set(channels, updated)
+	return nil
+}
+
+// keepChannelsFresh refreshes the channel snapshot on every channel event and
+// HTLC event and every channelRefreshInterval until ctx is done
+func (app *App) keepChannelsFresh(ctx context.Context) {
+	go func() {
+		stream, err := app.lnd.subscribeChannelEvents(ctx, &lnrpc.ChannelEventSubscription{})
+		if err != nil {
+			log.Errorf("[channels] Could not subscribe to channel events: %v", err)
+			return
+		}
+		for {
+			if _, err := stream.Recv(); err != nil {
+				return
+			}
+			app.channels.invalidate()
+		}
+	}()
+
+	ticker := time.NewTicker(channelRefreshInterval)
+	defer ticker.Stop()
+	for {
+		if err := app.refreshChannels(ctx); err != nil {
+			log.Errorf("[channels] Could not list channels: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(channelRefreshDelay):
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-app.channels.stale:
+		}
+	}
+}
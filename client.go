@@ -22,6 +22,8 @@ type lndclient interface {
 	getNodeAlias(ctx context.Context, pubkey string) (string, error)
 	getMyInfo(ctx context.Context) (*lnrpc.GetInfoResponse, error)
 	getPubKeyFromChannel(ctx context.Context, chan_id uint64) (*lnrpc.ChannelEdge, error)
+	listChannels(ctx context.Context) ([]*lnrpc.Channel, error)
+	pendingOpenChannels(ctx context.Context) ([]*lnrpc.PendingChannelsResponse_PendingOpenChannel, error)
 
 	subscribeHtlcEvents(ctx context.Context,
 		in *routerrpc.SubscribeHtlcEventsRequest) (
@@ -110,6 +112,31 @@ func (lnd *LndClient) getPubKeyFromChannel(ctx context.Context, chan_id uint64)
 	}, nil
 }
 
+// listChannels returns the open channels of my node
+func (lnd *LndClient) listChannels(ctx context.Context) ([]*lnrpc.Channel, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	resp, err := lnd.client.ListChannels(ctx, &lnrpc.ListChannelsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Channels, nil
+}
+
+// pendingOpenChannels returns the channels of my node that wait for the
+// confirmation of their funding transaction
+func (lnd *LndClient) pendingOpenChannels(ctx context.Context) ([]*lnrpc.PendingChannelsResponse_PendingOpenChannel, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	resp, err := lnd.client.PendingChannels(ctx, &lnrpc.PendingChannelsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.PendingOpenChannels, nil
+}
+
 func (lnd *LndClient) subscribeHtlcEvents(ctx context.Context,
 	in *routerrpc.SubscribeHtlcEventsRequest) (
 	routerrpc.Router_SubscribeHtlcEventsClient, error) {
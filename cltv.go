@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/callebtc/electronwall/config"
+	"github.com/callebtc/electronwall/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// blockHeightInterval is how often the block height is refreshed
+const blockHeightInterval = 30 * time.Second
+
+// pollBlockHeight keeps the block height current until ctx is done
+func (app *App) pollBlockHeight(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := app.lnd.getMyInfo(ctx)
+			if err != nil {
+				log.Errorf("[forward] Could not get the block height: %v", err)
+				continue
+			}
+			app.blockHeight.Store(info.BlockHeight)
+			app.expireHtlcs(info.BlockHeight)
+		}
+	}
+}
+
+// expireHtlcs drops the tracked HTLCs and lifecycles that expired before the
+// block height without a settle or fail event
+func (app *App) expireHtlcs(height uint32) {
+	if n := app.inflight.expire(height); n > 0 {
+		log.Warnf("[forward] Dropped %d in-flight HTLCs that expired without a settle or fail event", n)
+	}
+	if n := app.lifecycles.expire(height); n > 0 {
+		log.Warnf("[forward] Dropped %d HTLC lifecycles that expired without a settle or fail event", n)
+	}
+}
+
+// setCltv fills in the timelock of the forward. The blocks until expiry are
+// only known with the block height.
+func (app *App) setCltv(fwd *types.HtlcForwardEvent) {
+	fwd.BlockHeight = app.blockHeight.Load()
+	fwd.CltvDelta = int64(fwd.Event.IncomingExpiry) - int64(fwd.Event.OutgoingExpiry)
+	if fwd.BlockHeight > 0 {
+		fwd.BlocksUntilIncomingExpiry = int64(fwd.Event.IncomingExpiry) - int64(fwd.BlockHeight)
+		fwd.BlocksUntilOutgoingExpiry = int64(fwd.Event.OutgoingExpiry) - int64(fwd.BlockHeight)
+	}
+}
+
+// checkCltv returns whether the timelock of a forward is within the
+// thresholds of the CLTV policy and which one it violates if it is not
+func checkCltv(conf *config.Config, fwd types.HtlcForwardEvent) (string, bool) {
+	limits := conf.Cltv(fwd.Event.IncomingCircuitKey.ChanId, fwd.Event.OutgoingRequestedChanId)
+	if limits.MinDelta > 0 && fwd.CltvDelta < int64(limits.MinDelta) {
+		return fmt.Sprintf("cltv policy: delta %d below %d blocks", fwd.CltvDelta, limits.MinDelta), false
+	}
+	if limits.MaxDelta > 0 && fwd.CltvDelta > int64(limits.MaxDelta) {
+		return fmt.Sprintf("cltv policy: delta %d above %d blocks", fwd.CltvDelta, limits.MaxDelta), false
+	}
+	if limits.MaxExpiryBlocks > 0 && fwd.BlockHeight > 0 && fwd.BlocksUntilOutgoingExpiry > int64(limits.MaxExpiryBlocks) {
+		return fmt.Sprintf("cltv policy: outgoing expiry in %d blocks, more than %d", fwd.BlocksUntilOutgoingExpiry, limits.MaxExpiryBlocks), false
+	}
+	return "", true
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"fmt"
+)
+
+// ChannelCapsConfig are caps on the channels of the node. The channel that
+// is requested counts toward them. Unset caps are not enforced.
+type ChannelCapsConfig struct {
+	// MaxChannels and MaxCapacitySat cap the open and pending channels
+	// with the peer that requests a channel
+	MaxChannels    *uint32 `yaml:"max-channels"`
+	MaxCapacitySat *uint64 `yaml:"max-capacity-sat"`
+	// MaxPendingInbound caps the pending channels that any peers opened
+	MaxPendingInbound *uint32 `yaml:"max-pending-inbound"`
+}
+
+// ChannelCapsPolicy is the channel-caps section of the config file. Entries
+// under Peers override the caps for a peer.
+type ChannelCapsPolicy struct {
+	ChannelCapsConfig `yaml:",inline"`
+	Peers             []PeerCapsConfig `yaml:"peers"`
+}
+
+// PeerCapsConfig overrides the channel caps for a peer. Unset caps are taken
+// from the global caps.
+type PeerCapsConfig struct {
+	Peer              string `yaml:"peer"`
+	ChannelCapsConfig `yaml:",inline"`
+}
+
+// PeerCaps is a parsed PeerCapsConfig
+type PeerCaps struct {
+	Pubkey string
+	Caps   ChannelCapsConfig
+}
+
+// Enforced returns whether any cap is set
+func (cc ChannelCapsConfig) Enforced() bool {
+	return cc.MaxChannels != nil || cc.MaxCapacitySat != nil || cc.MaxPendingInbound != nil
+}
+
+// merge returns the caps with the ones that o sets replaced
+func (cc ChannelCapsConfig) merge(o ChannelCapsConfig) ChannelCapsConfig {
+	if o.MaxChannels != nil {
+		cc.MaxChannels = o.MaxChannels
+	}
+	if o.MaxCapacitySat != nil {
+		cc.MaxCapacitySat = o.MaxCapacitySat
+	}
+	if o.MaxPendingInbound != nil {
+		cc.MaxPendingInbound = o.MaxPendingInbound
+	}
+	return cc
+}
+
+// ChannelCaps returns the channel caps for the peer with the hex encoded
+// pubkey. The first matching peer entry applies.
+func (c *Config) ChannelCaps(pubkey string) ChannelCapsConfig {
+	for _, p := range c.PeerCaps {
+		if p.Pubkey == pubkey {
+			return c.ChannelCapsPolicy.ChannelCapsConfig.merge(p.Caps)
+		}
+	}
+	return c.ChannelCapsPolicy.ChannelCapsConfig
+}
+
+// checkChannelCaps parses the channel-caps section
+func (c *Config) checkChannelCaps() []error {
+	var errs []error
+	c.PeerCaps = nil
+	for i, pc := range c.ChannelCapsPolicy.Peers {
+		peer, err := ParsePeerMatch(pc.Peer)
+		if err == nil && peer.Pubkey == "" {
+			err = fmt.Errorf("expected a pubkey, not a wildcard")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("channel-caps: peers entry %d: %w", i+1, err))
+			continue
+		}
+		c.PeerCaps = append(c.PeerCaps, PeerCaps{Pubkey: peer.Pubkey, Caps: pc.ChannelCapsConfig})
+	}
+	return errs
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+// CltvPolicyConfig is the forward-cltv-policy section of the config file. A
+// zero threshold is not enforced.
+type CltvPolicyConfig struct {
+	// MinDelta and MaxDelta bound the incoming minus the outgoing expiry
+	MinDelta uint32 `yaml:"min-delta"`
+	MaxDelta uint32 `yaml:"max-delta"`
+	// MaxExpiryBlocks bounds the blocks until the outgoing expiry
+	MaxExpiryBlocks uint32               `yaml:"max-expiry-blocks"`
+	Channels        []CltvOverrideConfig `yaml:"channels"`
+	FailureCode     string               `yaml:"failure-code"`
+}
+
+// CltvOverrideConfig overrides the thresholds for an outgoing channel or a
+// channel pair. Unset thresholds are taken from the global policy.
+type CltvOverrideConfig struct {
+	Channel         string  `yaml:"channel"`
+	Pair            string  `yaml:"pair"`
+	MinDelta        *uint32 `yaml:"min-delta"`
+	MaxDelta        *uint32 `yaml:"max-delta"`
+	MaxExpiryBlocks *uint32 `yaml:"max-expiry-blocks"`
+}
+
+// CltvOverride is a parsed CltvOverrideConfig
+type CltvOverride struct {
+	Channels        ChannelPairMatch
+	MinDelta        *uint32
+	MaxDelta        *uint32
+	MaxExpiryBlocks *uint32
+}
+
+// CltvLimits are the thresholds that apply to a forward
+type CltvLimits struct {
+	MinDelta        uint32
+	MaxDelta        uint32
+	MaxExpiryBlocks uint32
+}
+
+// ParseCltvOverride parses a forward-cltv-policy channels entry
+func ParseCltvOverride(oc CltvOverrideConfig) (CltvOverride, error) {
+	channels, err := parseOverrideMatch(oc.Channel, oc.Pair)
+	if err != nil {
+		return CltvOverride{}, err
+	}
+	return CltvOverride{
+		Channels:        channels,
+		MinDelta:        oc.MinDelta,
+		MaxDelta:        oc.MaxDelta,
+		MaxExpiryBlocks: oc.MaxExpiryBlocks,
+	}, nil
+}
+
+// Cltv returns the thresholds for a forward from channel in to channel out.
+// The first matching override applies.
+func (c *Config) Cltv(in, out uint64) CltvLimits {
+	p := c.ForwardCltvPolicy
+	limits := CltvLimits{MinDelta: p.MinDelta, MaxDelta: p.MaxDelta, MaxExpiryBlocks: p.MaxExpiryBlocks}
+	for _, o := range c.CltvOverrides {
+		if !o.Channels.Matches(in, out) {
+			continue
+		}
+		if o.MinDelta != nil {
+			limits.MinDelta = *o.MinDelta
+		}
+		if o.MaxDelta != nil {
+			limits.MaxDelta = *o.MaxDelta
+		}
+		if o.MaxExpiryBlocks != nil {
+			limits.MaxExpiryBlocks = *o.MaxExpiryBlocks
+		}
+		break
+	}
+	return limits
+}
+
+// checkCltvPolicy parses the forward-cltv-policy section
+func (c *Config) checkCltvPolicy() []error {
+	var errs []error
+	c.CltvOverrides = nil
+	for i, oc := range c.ForwardCltvPolicy.Channels {
+		o, err := ParseCltvOverride(oc)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("forward-cltv-policy: channels entry %d: %w", i+1, err))
+			continue
+		}
+		c.CltvOverrides = append(c.CltvOverrides, o)
+	}
+	if len(c.ForwardCltvPolicy.FailureCode) == 0 {
+		c.ForwardCltvPolicy.FailureCode = lnrpc.Failure_TEMPORARY_CHANNEL_FAILURE.String()
+	}
+	var err error
+	c.CltvFailure, err = ParseFailureCode(c.ForwardCltvPolicy.FailureCode)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("forward-cltv-policy: failure-code: %w", err))
+	}
+	return errs
+}
@@ -1,26 +1,84 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
 
 	"github.com/jinzhu/configor"
+	"github.com/lightningnetwork/lnd/lnrpc"
 	log "github.com/sirupsen/logrus"
 )
 
-var Configuration = struct {
+type Config struct {
 	ChannelMode          string   `yaml:"channel-mode"`
 	Host                 string   `yaml:"host"`
+	LndDir               string   `yaml:"lnddir"`
+	Network              string   `yaml:"network"`
 	MacaroonPath         string   `yaml:"macaroon_path"`
 	TLSPath              string   `yaml:"tls-path"`
+	RulesDir             string   `yaml:"rules-dir"`
 	Debug                bool     `yaml:"debug"`
 	LogJson              bool     `yaml:"log-json"`
 	ChannelAllowlist     []string `yaml:"channel-allowlist"`
 	ChannelDenylist      []string `yaml:"channel-denylist"`
+	ChannelChain         []string `yaml:"channel-chain"`
+	ChannelDefault       string   `yaml:"channel-default"`
+	ChannelPolicy        string   `yaml:"channel-combine-policy"`
+	ChannelMonitorMode   string   `yaml:"channel-monitor-mode"`
 	ChannelRejectMessage string   `yaml:"channel-reject-message"`
 	ForwardMode          string   `yaml:"forward-mode"`
 	ForwardAllowlist     []string `yaml:"forward-allowlist"`
 	ForwardDenylist      []string `yaml:"forward-denylist"`
-	ApiRules             struct {
+	ForwardChain         []string `yaml:"forward-chain"`
+	ForwardDefault       string   `yaml:"forward-default"`
+	ForwardPolicy        string   `yaml:"forward-combine-policy"`
+	ForwardMonitorMode   string   `yaml:"forward-monitor-mode"`
+	ForwardFailureCode   string   `yaml:"forward-failure-code"`
+	// ForwardRateLimits limit the HTLCs that are forwarded per incoming
+	// channel, incoming peer or channel pair. HTLCs over a limit fail with
+	// ForwardRateLimitFailureCode.
+	ForwardRateLimits           []RateLimitConfig `yaml:"forward-rate-limits"`
+	ForwardRateLimitFailureCode string            `yaml:"forward-rate-limit-failure-code"`
+	// ForwardInflightLimits cap the HTLCs that are in flight at the same
+	// time per incoming peer and per outgoing channel. HTLCs over a cap
+	// fail with ForwardInflightFailureCode.
+	ForwardInflightLimits      InflightLimitsConfig  `yaml:"forward-inflight-limits"`
+	ForwardInflightFailureCode string                `yaml:"forward-inflight-failure-code"`
+	ForwardReputation          ReputationConfig      `yaml:"forward-reputation"`
+	ForwardFeePolicy           FeePolicyConfig       `yaml:"forward-fee-policy"`
+	ForwardCltvPolicy          CltvPolicyConfig      `yaml:"forward-cltv-policy"`
+	ForwardLiquidityPolicy     LiquidityPolicyConfig `yaml:"forward-liquidity-policy"`
+	ForwardFlowPolicy          FlowPolicyConfig      `yaml:"forward-flow-policy"`
+	ForwardCustomRecords       CustomRecordsConfig   `yaml:"forward-custom-records"`
+	// ForwardFallbackAction is used for HTLCs whose evaluation failed or
+	// did not finish within ForwardEvaluationTimeout seconds
+	ForwardFallbackAction    string `yaml:"forward-fallback-action"`
+	ForwardEvaluationTimeout int    `yaml:"forward-evaluation-timeout"`
+	// ForwardWorkers evaluate up to ForwardQueueSize queued HTLCs. HTLCs
+	// that do not fit into the queue are resolved with ForwardOverloadAction.
+	ForwardWorkers        int    `yaml:"forward-workers"`
+	ForwardQueueSize      int    `yaml:"forward-queue-size"`
+	ForwardOverloadAction string `yaml:"forward-overload-action"`
+	// ChannelConstraintsPolicy limits the parameters of channel open
+	// requests. Requests that violate it are rejected with the reasons
+	// instead of ChannelRejectMessage.
+	ChannelConstraintsPolicy ChannelConstraintsPolicy `yaml:"channel-constraints"`
+	// ChannelParamsPolicy sets the channel parameters of accepted channels
+	ChannelParamsPolicy ChannelParamsPolicy `yaml:"channel-accept-params"`
+	// ChannelCapsPolicy limits the channels that a peer has with the node
+	// and the pending inbound channels
+	ChannelCapsPolicy ChannelCapsPolicy `yaml:"channel-caps"`
+	// ChannelRateLimit rejects channel open requests of peers that send
+	// too many before they are looked up
+	ChannelRateLimit ChannelRateLimitConfig `yaml:"channel-rate-limit"`
+	// MonitorSummaryInterval is the number of seconds between summaries
+	// of the requests that monitor mode would have denied
+	MonitorSummaryInterval int `yaml:"monitor-summary-interval"`
+	ApiRules               struct {
 		Apply bool `yaml:"apply"`
 		OneMl struct {
 			Active  bool `yaml:"active"`
@@ -31,48 +89,456 @@ var Configuration = struct {
 			Timeout int  `yaml:"timeout"`
 		} `yaml:"amboss"`
 	} `yaml:"rules"`
-}{}
 
-func init() {
-	err := configor.Load(&Configuration, "config.yaml")
+	// the active rule chains, filled in when the configuration is checked.
+	// The first matching rule decides, otherwise the default applies. The
+	// allowlist and denylist modes are chains with a single action.
+	ChannelRules         []ChannelRule `yaml:"-"`
+	ChannelDefaultAccept bool          `yaml:"-"`
+	ForwardRules         []ForwardRule `yaml:"-"`
+	ForwardDefaultAccept bool          `yaml:"-"`
+
+	// PeerConstraints are the parsed per peer channel constraints
+	PeerConstraints []PeerConstraints `yaml:"-"`
+	// ChannelParamsTiers are the parsed tiers of channel parameters
+	ChannelParamsTiers []ChannelParamsTier `yaml:"-"`
+	// PeerCaps are the parsed per peer channel caps
+	PeerCaps []PeerCaps `yaml:"-"`
+
+	// ForwardFailure is the failure code for denied HTLCs unless the rule
+	// that denied them chose one
+	ForwardFailure lnrpc.Failure_FailureCode `yaml:"-"`
+
+	// the parsed rate limits and the failure code for HTLCs over a limit
+	RateLimits       []RateLimit               `yaml:"-"`
+	RateLimitFailure lnrpc.Failure_FailureCode `yaml:"-"`
+
+	// the parsed in-flight caps and the failure code for HTLCs over a cap
+	InflightLimits  InflightLimits            `yaml:"-"`
+	InflightFailure lnrpc.Failure_FailureCode `yaml:"-"`
+
+	// the parsed reputation slot cap, balance floor and the failure code
+	// for HTLCs of peers with a low reputation
+	ReputationMaxChannelHtlcs Slots                     `yaml:"-"`
+	ReputationMinLocalBalance Floor                     `yaml:"-"`
+	ReputationFailure         lnrpc.Failure_FailureCode `yaml:"-"`
+
+	// the parsed fee overrides and the failure code for HTLCs that pay too
+	// little fee
+	FeeOverrides []FeeOverride             `yaml:"-"`
+	FeeFailure   lnrpc.Failure_FailureCode `yaml:"-"`
+
+	// the parsed CLTV overrides and the failure code for HTLCs with a risky
+	// timelock
+	CltvOverrides []CltvOverride            `yaml:"-"`
+	CltvFailure   lnrpc.Failure_FailureCode `yaml:"-"`
+
+	// the parsed local balance floors and the failure code for HTLCs that
+	// would drain the outgoing channel below its floor
+	LiquidityFloor   Floor                     `yaml:"-"`
+	LiquidityFloors  []LiquidityFloor          `yaml:"-"`
+	LiquidityFailure lnrpc.Failure_FailureCode `yaml:"-"`
+
+	// the parsed actions for same-peer forwards and forwards between
+	// channel groups, and the failure code for forwards that they deny
+	SamePeerFlow FlowAction                `yaml:"-"`
+	GroupFlows   []GroupFlow               `yaml:"-"`
+	FlowFailure  lnrpc.Failure_FailureCode `yaml:"-"`
+
+	// CustomRecordsFailure is the failure code for forwards with denied
+	// custom records
+	CustomRecordsFailure lnrpc.Failure_FailureCode `yaml:"-"`
+
+	// in monitor mode, everything is evaluated but all requests are accepted
+	ChannelMonitor bool `yaml:"-"`
+	ForwardMonitor bool `yaml:"-"`
+
+	// source is the file the configuration was loaded from and lines holds
+	// the line numbers of its list entries for error messages
+	source string
+	lines  map[string][]int
+}
+
+// Policies that combine the list decision and the rules decision
+const (
+	// PolicyAll accepts if both the list and the rules accept
+	PolicyAll = "all"
+	// PolicyAny accepts if either the list or the rules accept
+	PolicyAny = "any"
+	// PolicyListOnly ignores the rules
+	PolicyListOnly = "list-only"
+	// PolicyRulesOnly ignores the list
+	PolicyRulesOnly = "rules-only"
+	// PolicyRulesOverride uses the rules decision if the script returned one
+	// and the list decision otherwise
+	PolicyRulesOverride = "rules-override"
+)
+
+func validPolicy(policy string) bool {
+	switch policy {
+	case PolicyAll, PolicyAny, PolicyListOnly, PolicyRulesOnly, PolicyRulesOverride:
+		return true
+	}
+	return false
+}
+
+// EnvPrefix is the prefix of environment variables that override config
+// keys, e.g. ELECTRONWALL_FORWARDMODE or ELECTRONWALL_APIRULES_ONEML_ACTIVE
+const EnvPrefix = "ELECTRONWALL"
+
+// NetworkMainnet is the default network
+const NetworkMainnet = "mainnet"
+
+// Networks are the networks that LND can run on
+var Networks = []string{NetworkMainnet, "testnet", "signet", "regtest", "simnet"}
+
+// ConfigPath is the file the configuration is loaded from and reloaded from
+var ConfigPath = "config.yaml"
+
+// Overrides are applied on top of every loaded configuration. They are set
+// from command line flags and take precedence over the file and the
+// environment.
+var Overrides struct {
+	LndDir   string
+	RulesDir string
+}
+
+// current holds the active configuration. It is only ever replaced as a
+// whole so readers always see a consistent version.
+var current atomic.Pointer[Config]
+
+// Init loads the configuration at path and makes it the active one
+func Init(path string) error {
+	ConfigPath = path
+	c, err := Load(path)
+	if err != nil {
+		return err
+	}
+	current.Store(c)
+	c.logModes()
+	return nil
+}
+
+// Current returns the active configuration. Callers that make a decision
+// should fetch it once and use that snapshot throughout. The returned
+// value must not be modified.
+func Current() *Config {
+	return current.Load()
+}
+
+// Load reads and validates the configuration at path without activating it
+func Load(path string) (*Config, error) {
+	c := &Config{}
+	err := configor.New(&configor.Config{ENVPrefix: EnvPrefix}).Load(c, path)
+	if err != nil {
+		return nil, err
+	}
+	c.source = path
+	c.lines = listLines(path)
+	if Overrides.LndDir != "" {
+		c.LndDir = Overrides.LndDir
+	}
+	if Overrides.RulesDir != "" {
+		// the flag is relative to the working directory, not to the
+		// config file
+		c.RulesDir = Overrides.RulesDir
+		if dir, err := filepath.Abs(c.RulesDir); err == nil {
+			c.RulesDir = dir
+		}
+	}
+	err = c.check()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	checkConfig()
+	return c, nil
 }
 
-func checkConfig() {
+// Set validates c and makes it the active configuration
+func Set(c *Config) error {
+	err := c.check()
+	if err != nil {
+		return err
+	}
+	current.Store(c)
+	return nil
+}
 
-	if Configuration.Host == "" {
-		panic(fmt.Errorf("no host specified in config.yaml"))
+// Reload reads the configuration from ConfigPath and swaps it in. If the new
+// configuration is invalid, the previous one stays active.
+func Reload() error {
+	c, err := Load(ConfigPath)
+	if err != nil {
+		return err
 	}
-	if Configuration.MacaroonPath == "" {
-		panic(fmt.Errorf("no macaroon path specified in config.yaml"))
+	old := current.Swap(c)
+	if old != nil && ((old.ChannelMode == "passthrough") != (c.ChannelMode == "passthrough") ||
+		(old.ForwardMode == "passthrough") != (c.ForwardMode == "passthrough")) {
+		log.Warnf("Switching from or to passthrough mode only takes full effect after a restart")
 	}
-	if Configuration.TLSPath == "" {
-		panic(fmt.Errorf("no tls path specified in config.yaml"))
+	if old != nil && old.RulesDir != c.RulesDir {
+		log.Warnf("Changing rules-dir only takes effect after a restart, still using %s", old.RulesDir)
 	}
+	c.logModes()
+	return nil
+}
 
-	if len(Configuration.ChannelRejectMessage) > 500 {
+// Copy returns a copy of the configuration that can be modified and passed to Set
+func (c *Config) Copy() *Config {
+	n := *c
+	n.ChannelAllowlist = append([]string(nil), c.ChannelAllowlist...)
+	n.ChannelDenylist = append([]string(nil), c.ChannelDenylist...)
+	n.ForwardAllowlist = append([]string(nil), c.ForwardAllowlist...)
+	n.ForwardDenylist = append([]string(nil), c.ForwardDenylist...)
+	n.ChannelChain = append([]string(nil), c.ChannelChain...)
+	n.ForwardChain = append([]string(nil), c.ForwardChain...)
+	n.ForwardRateLimits = append([]RateLimitConfig(nil), c.ForwardRateLimits...)
+	n.ForwardFeePolicy.Channels = append([]FeeOverrideConfig(nil), c.ForwardFeePolicy.Channels...)
+	n.ForwardCltvPolicy.Channels = append([]CltvOverrideConfig(nil), c.ForwardCltvPolicy.Channels...)
+	n.ForwardLiquidityPolicy.Channels = append([]LiquidityFloorConfig(nil), c.ForwardLiquidityPolicy.Channels...)
+	n.ForwardFlowPolicy.Groups = make(map[string][]string, len(c.ForwardFlowPolicy.Groups))
+	for name, ids := range c.ForwardFlowPolicy.Groups {
+		n.ForwardFlowPolicy.Groups[name] = append([]string(nil), ids...)
+	}
+	n.ForwardFlowPolicy.BetweenGroups = append([]GroupFlowConfig(nil), c.ForwardFlowPolicy.BetweenGroups...)
+	n.ForwardCustomRecords.Allow = append([]uint64(nil), c.ForwardCustomRecords.Allow...)
+	n.ForwardCustomRecords.Deny = append([]uint64(nil), c.ForwardCustomRecords.Deny...)
+	n.ChannelConstraintsPolicy.Peers = append([]PeerConstraintsConfig(nil), c.ChannelConstraintsPolicy.Peers...)
+	n.ChannelConstraintsPolicy.CommitmentTypes = append([]string(nil), c.ChannelConstraintsPolicy.CommitmentTypes...)
+	n.ChannelParamsPolicy.Tiers = append([]ChannelParamsTierConfig(nil), c.ChannelParamsPolicy.Tiers...)
+	n.ChannelCapsPolicy.Peers = append([]PeerCapsConfig(nil), c.ChannelCapsPolicy.Peers...)
+	// line numbers of the file are no longer accurate once the copy is modified
+	n.lines = nil
+	return &n
+}
+
+// check validates the configuration, fills in defaults and parses all list
+// entries. All problems that were found are returned together.
+func (c *Config) check() error {
+	var errs []error
+
+	if c.Network == "" {
+		c.Network = NetworkMainnet
+	}
+	if !slices.Contains(Networks, c.Network) {
+		errs = append(errs, fmt.Errorf("invalid network %q: expected one of %s", c.Network, strings.Join(Networks, ", ")))
+	}
+	if c.LndDir != "" {
+		if c.MacaroonPath == "" {
+			c.MacaroonPath = filepath.Join(c.LndDir, "data", "chain", "bitcoin", c.Network, "admin.macaroon")
+		}
+		if c.TLSPath == "" {
+			c.TLSPath = filepath.Join(c.LndDir, "tls.cert")
+		}
+	}
+	if c.RulesDir == "" {
+		c.RulesDir = "rules"
+	}
+	if !filepath.IsAbs(c.RulesDir) {
+		c.RulesDir = filepath.Join(filepath.Dir(c.source), c.RulesDir)
+	}
+
+	if c.Host == "" {
+		errs = append(errs, fmt.Errorf("no host specified in config"))
+	}
+	if c.MacaroonPath == "" {
+		errs = append(errs, fmt.Errorf("no macaroon path specified in config"))
+	}
+	if c.TLSPath == "" {
+		errs = append(errs, fmt.Errorf("no tls path specified in config"))
+	}
+
+	if len(c.ChannelRejectMessage) > 500 {
 		log.Warnf("channel reject message is too long. Trimming to 500 characters.")
-		Configuration.ChannelRejectMessage = Configuration.ChannelRejectMessage[:500]
+		c.ChannelRejectMessage = c.ChannelRejectMessage[:500]
 	}
 
-	if len(Configuration.ChannelMode) == 0 {
-		Configuration.ChannelMode = "denylist"
+	if len(c.ChannelMode) == 0 {
+		c.ChannelMode = "denylist"
+	}
+	if c.ChannelMode != "allowlist" && c.ChannelMode != "denylist" && c.ChannelMode != "chain" && c.ChannelMode != "monitor" && c.ChannelMode != "passthrough" {
+		errs = append(errs, fmt.Errorf("channel mode must be either allowlist, denylist, chain, monitor or passthrough"))
 	}
-	if Configuration.ChannelMode != "allowlist" && Configuration.ChannelMode != "denylist" && Configuration.ChannelMode != "passthrough" {
-		panic(fmt.Errorf("channel mode must be either allowlist, denylist or passthrough"))
+	if len(c.ChannelMonitorMode) == 0 {
+		c.ChannelMonitorMode = "denylist"
 	}
+	if c.ChannelMonitorMode != "allowlist" && c.ChannelMonitorMode != "denylist" && c.ChannelMonitorMode != "chain" {
+		errs = append(errs, fmt.Errorf("channel monitor mode must be either allowlist, denylist or chain"))
+	}
+	c.ChannelMonitor = c.ChannelMode == "monitor"
 
-	log.Infof("Channel acceptor running in %s mode", Configuration.ChannelMode)
+	if len(c.ForwardMode) == 0 {
+		c.ForwardMode = "denylist"
+	}
+	if c.ForwardMode != "allowlist" && c.ForwardMode != "denylist" && c.ForwardMode != "chain" && c.ForwardMode != "monitor" && c.ForwardMode != "passthrough" {
+		errs = append(errs, fmt.Errorf("forward mode must be either allowlist, denylist, chain, monitor or passthrough"))
+	}
+	if len(c.ForwardMonitorMode) == 0 {
+		c.ForwardMonitorMode = "denylist"
+	}
+	if c.ForwardMonitorMode != "allowlist" && c.ForwardMonitorMode != "denylist" && c.ForwardMonitorMode != "chain" {
+		errs = append(errs, fmt.Errorf("forward monitor mode must be either allowlist, denylist or chain"))
+	}
+	c.ForwardMonitor = c.ForwardMode == "monitor"
 
-	if len(Configuration.ForwardMode) == 0 {
-		Configuration.ForwardMode = "denylist"
+	if len(c.ForwardFallbackAction) == 0 {
+		c.ForwardFallbackAction = "fail"
+	}
+	if c.ForwardFallbackAction != "resume" && c.ForwardFallbackAction != "fail" {
+		errs = append(errs, fmt.Errorf("forward fallback action must be either resume or fail"))
+	}
+	if c.ForwardEvaluationTimeout <= 0 {
+		c.ForwardEvaluationTimeout = 10
+	}
+	if c.ForwardWorkers <= 0 {
+		c.ForwardWorkers = 8
+	}
+	if c.ForwardQueueSize < 0 {
+		errs = append(errs, fmt.Errorf("forward queue size must not be negative"))
+	}
+	if c.ForwardQueueSize == 0 {
+		c.ForwardQueueSize = 100
+	}
+	if len(c.ForwardOverloadAction) == 0 {
+		c.ForwardOverloadAction = "fail"
+	}
+	if c.ForwardOverloadAction != "resume" && c.ForwardOverloadAction != "fail" {
+		errs = append(errs, fmt.Errorf("forward overload action must be either resume or fail"))
+	}
+
+	if c.MonitorSummaryInterval == 0 {
+		c.MonitorSummaryInterval = 600
+	}
+	if c.MonitorSummaryInterval < 0 {
+		errs = append(errs, fmt.Errorf("monitor summary interval must not be negative"))
+	}
+
+	if len(c.ChannelPolicy) == 0 {
+		c.ChannelPolicy = PolicyAll
+	}
+	if !validPolicy(c.ChannelPolicy) {
+		errs = append(errs, fmt.Errorf("channel-combine-policy must be either all, any, list-only, rules-only or rules-override"))
+	}
+	if len(c.ForwardPolicy) == 0 {
+		c.ForwardPolicy = PolicyAll
+	}
+	if !validPolicy(c.ForwardPolicy) {
+		errs = append(errs, fmt.Errorf("forward-combine-policy must be either all, any, list-only, rules-only or rules-override"))
+	}
+
+	// all lists are parsed, even the ones of inactive modes, so that
+	// mistakes show up before a mode is switched
+	channelAllowlist, err := parseList(c, "channel-allowlist", c.ChannelAllowlist, listEntry("allow", ParseChannelRule))
+	errs = append(errs, err)
+	channelDenylist, err := parseList(c, "channel-denylist", c.ChannelDenylist, listEntry("deny", ParseChannelRule))
+	errs = append(errs, err)
+	channelChain, err := parseList(c, "channel-chain", c.ChannelChain, ParseChannelRule)
+	errs = append(errs, err)
+	if len(c.ChannelDefault) == 0 {
+		c.ChannelDefault = "deny"
+	}
+	channelDefault, err := parseAction(c.ChannelDefault)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("channel-default: %w", err))
+	}
+	// monitor mode evaluates the lists of the channel monitor mode
+	channelMode := c.ChannelMode
+	if c.ChannelMonitor {
+		channelMode = c.ChannelMonitorMode
 	}
-	if Configuration.ForwardMode != "allowlist" && Configuration.ForwardMode != "denylist" && Configuration.ForwardMode != "passthrough" {
-		panic(fmt.Errorf("forward mode must be either allowlist, denylist or passthrough"))
+	switch channelMode {
+	case "allowlist":
+		c.ChannelRules, c.ChannelDefaultAccept = channelAllowlist, false
+	case "denylist":
+		c.ChannelRules, c.ChannelDefaultAccept = channelDenylist, true
+	case "chain":
+		c.ChannelRules, c.ChannelDefaultAccept = channelChain, channelDefault
 	}
 
-	log.Infof("HTLC forwarder running in %s mode", Configuration.ForwardMode)
-}
\ No newline at end of file
+	forwardAllowlist, err := parseList(c, "forward-allowlist", c.ForwardAllowlist, listEntry("allow", ParseForwardRule))
+	errs = append(errs, err)
+	forwardDenylist, err := parseList(c, "forward-denylist", c.ForwardDenylist, listEntry("deny", ParseForwardRule))
+	errs = append(errs, err)
+	forwardChain, err := parseList(c, "forward-chain", c.ForwardChain, ParseForwardRule)
+	errs = append(errs, err)
+	if len(c.ForwardDefault) == 0 {
+		c.ForwardDefault = "deny"
+	}
+	forwardDefault, err := parseAction(c.ForwardDefault)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("forward-default: %w", err))
+	}
+	if len(c.ForwardFailureCode) == 0 {
+		c.ForwardFailureCode = lnrpc.Failure_TEMPORARY_CHANNEL_FAILURE.String()
+	}
+	c.ForwardFailure, err = ParseFailureCode(c.ForwardFailureCode)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("forward-failure-code: %w", err))
+	}
+
+	c.RateLimits = nil
+	for i, rc := range c.ForwardRateLimits {
+		l, err := ParseRateLimit(rc)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.entryPos("forward-rate-limits", i, len(c.ForwardRateLimits)), err))
+			continue
+		}
+		c.RateLimits = append(c.RateLimits, l)
+	}
+	if len(c.ForwardRateLimitFailureCode) == 0 {
+		c.ForwardRateLimitFailureCode = lnrpc.Failure_TEMPORARY_CHANNEL_FAILURE.String()
+	}
+	c.RateLimitFailure, err = ParseFailureCode(c.ForwardRateLimitFailureCode)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("forward-rate-limit-failure-code: %w", err))
+	}
+
+	c.InflightLimits, err = ParseInflightLimits(c.ForwardInflightLimits)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("forward-inflight-limits: %w", err))
+	}
+	if len(c.ForwardInflightFailureCode) == 0 {
+		c.ForwardInflightFailureCode = lnrpc.Failure_TEMPORARY_CHANNEL_FAILURE.String()
+	}
+	c.InflightFailure, err = ParseFailureCode(c.ForwardInflightFailureCode)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("forward-inflight-failure-code: %w", err))
+	}
+	errs = append(errs, c.checkReputation()...)
+	errs = append(errs, c.checkFeePolicy()...)
+	errs = append(errs, c.checkCltvPolicy()...)
+	errs = append(errs, c.checkLiquidityPolicy()...)
+	errs = append(errs, c.checkFlowPolicy()...)
+	errs = append(errs, c.checkCustomRecords()...)
+	errs = append(errs, c.checkChannelConstraints()...)
+	errs = append(errs, c.checkChannelParams()...)
+	errs = append(errs, c.checkChannelCaps()...)
+	errs = append(errs, c.checkChannelRateLimit()...)
+
+	forwardMode := c.ForwardMode
+	if c.ForwardMonitor {
+		forwardMode = c.ForwardMonitorMode
+	}
+	switch forwardMode {
+	case "allowlist":
+		c.ForwardRules, c.ForwardDefaultAccept = forwardAllowlist, false
+	case "denylist":
+		c.ForwardRules, c.ForwardDefaultAccept = forwardDenylist, true
+	case "chain":
+		c.ForwardRules, c.ForwardDefaultAccept = forwardChain, forwardDefault
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *Config) logModes() {
+	if c.ChannelMonitor {
+		log.Infof("Channel acceptor running in monitor mode, evaluating the %s", c.ChannelMonitorMode)
+	} else {
+		log.Infof("Channel acceptor running in %s mode", c.ChannelMode)
+	}
+	if c.ForwardMonitor {
+		log.Infof("HTLC forwarder running in monitor mode, evaluating the %s", c.ForwardMonitorMode)
+	} else {
+		log.Infof("HTLC forwarder running in %s mode", c.ForwardMode)
+	}
+}
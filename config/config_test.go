@@ -0,0 +1,241 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePeerMatch(t *testing.T) {
+	_, err := ParsePeerMatch("03006fcf3312dae8d068ea297f58e2bd00ec1ffe214b793eda46966b6294a53ce6")
+	require.NoError(t, err)
+	_, err = ParsePeerMatch("*")
+	require.NoError(t, err)
+
+	// 65 characters
+	_, err = ParsePeerMatch("03006fcf3312dae8d068ea297f58e2bd00ec1ffe214b793eda46966b6294a53ce")
+	require.ErrorContains(t, err, "expected 66 hex characters, got 65")
+	_, err = ParsePeerMatch("04006fcf3312dae8d068ea297f58e2bd00ec1ffe214b793eda46966b6294a53ce6")
+	require.ErrorContains(t, err, "must start with 02 or 03")
+}
+
+func TestParseChannelPairMatch(t *testing.T) {
+	m, err := ParseChannelPairMatch("700762x1327x1")
+	require.NoError(t, err)
+	require.Equal(t, ChannelPairMatch{In: 770495967390531585}, m)
+
+	m, err = ParseChannelPairMatch("*->690757x1005x1")
+	require.NoError(t, err)
+	require.Equal(t, ChannelPairMatch{Out: 759495353533530113}, m)
+	require.True(t, m.Matches(1, 759495353533530113))
+	require.False(t, m.Matches(759495353533530113, 1))
+
+	_, err = ParseChannelPairMatch("700762:1327:1")
+	require.ErrorContains(t, err, "expected BLOCKxTXxOUTPUT")
+	_, err = ParseChannelPairMatch("700762x1327x1->*->*")
+	require.ErrorContains(t, err, "got 3 parts")
+}
+
+func TestParseForwardRule(t *testing.T) {
+	r, err := ParseForwardRule("allow *->25328x256x0")
+	require.NoError(t, err)
+	require.True(t, r.Accept)
+	require.Equal(t, "allow *->25328x256x0", r.String())
+
+	r, err = ParseForwardRule("deny 25328x256x0 invalid_onion_version")
+	require.NoError(t, err)
+	require.Equal(t, lnrpc.Failure_INVALID_ONION_VERSION, r.FailureCode)
+	require.Equal(t, "deny 25328x256x0 INVALID_ONION_VERSION", r.String())
+
+	_, err = ParseForwardRule("allow 25328x256x0 TEMPORARY_CHANNEL_FAILURE")
+	require.ErrorContains(t, err, "only follow a deny rule")
+	_, err = ParseForwardRule("deny 25328x256x0 FEE_INSUFFICIENT")
+	require.ErrorContains(t, err, "not supported by the LND HTLC interceptor")
+	_, err = ParseForwardRule("drop 25328x256x0")
+	require.ErrorContains(t, err, "expected allow or deny")
+	_, err = ParseForwardRule("deny")
+	require.ErrorContains(t, err, "expected ACTION MATCH")
+
+	peer := "03006fcf3312dae8d068ea297f58e2bd00ec1ffe214b793eda46966b6294a53ce6"
+	r, err = ParseForwardRule("deny " + peer)
+	require.NoError(t, err)
+	require.Equal(t, "deny "+peer, r.String())
+	require.True(t, r.Matches(1, 2, peer, "02ab"))
+	require.False(t, r.Matches(1, 2, "02ab", peer))
+
+	r, err = ParseForwardRule("allow *->" + peer)
+	require.NoError(t, err)
+	require.True(t, r.Matches(1, 2, "02ab", peer))
+	require.False(t, r.Matches(1, 2, peer, "02ab"))
+
+	_, err = ParseForwardRule("deny " + peer + "->25328x256x0")
+	require.ErrorContains(t, err, "invalid pubkey")
+	_, err = ParseForwardRule("deny 04" + peer[2:])
+	require.ErrorContains(t, err, "must start with 02 or 03")
+}
+
+// list errors point to the line of the entry in the config file
+func TestLoad_LinePreciseErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `host: "127.0.0.1:10009"
+macaroon_path: "admin.macaroon"
+tls-path: "tls.cert"
+forward-denylist:
+  - "700762x1327x1"
+  - "700762:1327:1"
+channel-allowlist:
+  - "*"
+  - "03006fcf3312dae8d068ea297f58e2bd00ec1ffe214b793eda46966b6294a53ce"
+`
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	_, err := Load(path)
+	require.ErrorContains(t, err, path+":6: forward-denylist entry \"700762:1327:1\"")
+	require.ErrorContains(t, err, path+":9: channel-allowlist entry")
+}
+
+func TestParseRateLimit(t *testing.T) {
+	l, err := ParseRateLimit(RateLimitConfig{Scope: "pair", Match: "700762x1327x1->*", HtlcsPerSecond: 0.5})
+	require.NoError(t, err)
+	require.Equal(t, float64(1), l.HtlcBurst)
+	require.Equal(t, "pair 700762x1327x1: 0.5 htlc/s (burst 1)", l.String())
+	_, ok := l.Subject(770495967390531585, 1, "")
+	require.True(t, ok)
+
+	_, err = ParseRateLimit(RateLimitConfig{Scope: "channel", Match: "*->700762x1327x1", HtlcsPerSecond: 1})
+	require.ErrorContains(t, err, "use scope pair")
+	_, err = ParseRateLimit(RateLimitConfig{Scope: "peer"})
+	require.ErrorContains(t, err, "expected htlcs-per-second or msat-per-minute")
+	_, err = ParseRateLimit(RateLimitConfig{Scope: "node", HtlcsPerSecond: 1})
+	require.ErrorContains(t, err, "invalid scope")
+}
+
+func TestParseSlots(t *testing.T) {
+	slots, err := ParseSlots("30%")
+	require.NoError(t, err)
+	require.Equal(t, 144, slots.Of(0))
+	require.Equal(t, 9, slots.Of(30))
+	require.Equal(t, "30%", slots.String())
+	slots, err = ParseSlots("1%")
+	require.NoError(t, err)
+	require.Equal(t, 1, slots.Of(30))
+	slots, err = ParseSlots("20")
+	require.NoError(t, err)
+	require.Equal(t, 20, slots.Of(30))
+
+	_, err = ParseSlots("500")
+	require.ErrorContains(t, err, "up to 483")
+	_, err = ParseSlots("150%")
+	require.ErrorContains(t, err, "between 0% and 100%")
+}
+
+func TestParseFloor(t *testing.T) {
+	floor, err := ParseFloor("20%")
+	require.NoError(t, err)
+	require.Equal(t, int64(200_000_000), floor.Msat(1_000_000))
+	require.Equal(t, "20%", floor.String())
+	floor, err = ParseFloor("500000")
+	require.NoError(t, err)
+	require.Equal(t, int64(500_000_000), floor.Msat(1_000_000))
+
+	_, err = ParseFloor("-1")
+	require.ErrorContains(t, err, "amount in sat")
+	_, err = ParseFloor("101%")
+	require.ErrorContains(t, err, "between 0% and 100%")
+	_, err = ParseLiquidityFloor(LiquidityFloorConfig{Peer: "*", MinLocalBalance: "10%"})
+	require.ErrorContains(t, err, "not a wildcard")
+}
+
+func TestParseFlowAction(t *testing.T) {
+	action, err := ParseFlowAction(FlowActionConfig{})
+	require.NoError(t, err)
+	require.True(t, action.Allows(-1))
+	action, err = ParseFlowAction(FlowActionConfig{Action: "min-fee", MinFeePpm: 100})
+	require.NoError(t, err)
+	require.False(t, action.Allows(99))
+	require.True(t, action.Allows(100))
+
+	_, err = ParseFlowAction(FlowActionConfig{Action: "min-fee"})
+	require.ErrorContains(t, err, "needs min-fee-ppm")
+	_, err = ParseFlowAction(FlowActionConfig{Action: "deny", MinFeePpm: 100})
+	require.ErrorContains(t, err, "only used with action min-fee")
+	_, err = ParseFlowAction(FlowActionConfig{Action: "reject"})
+	require.ErrorContains(t, err, "invalid action")
+}
+
+func TestCheckChannelConstraints(t *testing.T) {
+	minFunding, maxFunding := uint64(200000), uint64(100000)
+	c := &Config{ChannelConstraintsPolicy: ChannelConstraintsPolicy{
+		ChannelConstraintsConfig: ChannelConstraintsConfig{ChannelFlags: "secret"},
+		Peers: []PeerConstraintsConfig{
+			{Peer: "03006fcf3312dae8d068ea297f58e2bd00ec1ffe214b793eda46966b6294a53ce6",
+				ChannelConstraintsConfig: ChannelConstraintsConfig{MinFundingSat: &minFunding, MaxFundingSat: &maxFunding}},
+		},
+	}}
+	errs := c.checkChannelConstraints()
+	require.Len(t, errs, 2)
+	require.ErrorContains(t, errs[0], "invalid channel-flags")
+	require.ErrorContains(t, errs[1], "peers entry 1: min-funding-sat is above max-funding-sat")
+}
+
+func TestCheckChannelParams(t *testing.T) {
+	c := &Config{ChannelParamsPolicy: ChannelParamsPolicy{
+		Tiers: []ChannelParamsTierConfig{
+			{Peers: []string{"*"}},
+			{Name: "partners", Peers: []string{"03006fcf3312dae8d068ea297f58e2bd00ec1ffe214b793eda46966b6294a53ce6", "nope"}},
+		},
+	}}
+	errs := c.checkChannelParams()
+	require.Len(t, errs, 2)
+	require.ErrorContains(t, errs[0], "tiers entry 1: missing name")
+	require.ErrorContains(t, errs[1], "tier partners: peers entry 2")
+	require.Len(t, c.ChannelParamsTiers, 1)
+}
+
+func TestCheckChannelParams_Bounds(t *testing.T) {
+	htlcs, csv := uint32(1000), uint32(2016)
+	c := &Config{Network: NetworkMainnet, ChannelParamsPolicy: ChannelParamsPolicy{
+		ChannelParamsConfig: ChannelParamsConfig{MaxHtlcCount: &htlcs, CsvDelay: &csv},
+		Tiers: []ChannelParamsTierConfig{
+			{Name: "testnet", ChannelParamsConfig: ChannelParamsConfig{UpfrontShutdown: "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx"}},
+			{Name: "typo", ChannelParamsConfig: ChannelParamsConfig{UpfrontShutdown: "bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdx"}},
+			{Name: "mainnet", ChannelParamsConfig: ChannelParamsConfig{UpfrontShutdown: "bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq"}},
+		},
+	}}
+	errs := c.checkChannelParams()
+	require.Len(t, errs, 3)
+	require.ErrorContains(t, errs[0], "max-htlc-count 1000 is above 483")
+	require.ErrorContains(t, errs[1], "tier testnet: upfront shutdown address")
+	require.ErrorContains(t, errs[2], "tier typo: invalid upfront shutdown address")
+	require.Len(t, c.ChannelParamsTiers, 1)
+
+	c.Network = "testnet"
+	require.NoError(t, CheckShutdownAddress("tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx", c.Network))
+}
+
+func TestCheckChannelCaps(t *testing.T) {
+	maxChannels := uint32(1)
+	c := &Config{ChannelCapsPolicy: ChannelCapsPolicy{
+		Peers: []PeerCapsConfig{
+			{Peer: "*", ChannelCapsConfig: ChannelCapsConfig{MaxChannels: &maxChannels}},
+			{Peer: "03006fcf3312dae8d068ea297f58e2bd00ec1ffe214b793eda46966b6294a53ce6", ChannelCapsConfig: ChannelCapsConfig{MaxChannels: &maxChannels}},
+		},
+	}}
+	errs := c.checkChannelCaps()
+	require.Len(t, errs, 1)
+	require.ErrorContains(t, errs[0], "peers entry 1: expected a pubkey")
+	require.Equal(t, uint32(1), *c.ChannelCaps("03006fcf3312dae8d068ea297f58e2bd00ec1ffe214b793eda46966b6294a53ce6").MaxChannels)
+	require.False(t, c.ChannelCaps("02853f9c1d15d479b433039885373b681683b84bb73e86dff861bee6697c17c1de").Enforced())
+}
+
+func TestCheckChannelRateLimit(t *testing.T) {
+	c := &Config{ChannelRateLimit: ChannelRateLimitConfig{Global: 10, DenylistAfter: 3}}
+	errs := c.checkChannelRateLimit()
+	require.Len(t, errs, 1)
+	require.ErrorContains(t, errs[0], "denylist-after needs per-peer")
+	require.Equal(t, 600, c.ChannelRateLimit.Window)
+	require.Equal(t, 86400, c.ChannelRateLimit.DenylistDuration)
+}
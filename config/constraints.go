@@ -0,0 +1,166 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+// channel flags that channel-constraints can require
+const (
+	ChannelFlagsPublic  = "public"
+	ChannelFlagsPrivate = "private"
+)
+
+// answers to zero-conf requests
+const (
+	ZeroConfReject = "reject"
+	ZeroConfGrant  = "grant"
+)
+
+// ChannelConstraintsConfig are limits on the parameters of a channel open
+// request. Unset limits are not enforced.
+type ChannelConstraintsConfig struct {
+	MinFundingSat        *uint64 `yaml:"min-funding-sat"`
+	MaxFundingSat        *uint64 `yaml:"max-funding-sat"`
+	MaxPushMsat          *uint64 `yaml:"max-push-msat"`
+	ChannelFlags         string  `yaml:"channel-flags"`
+	MinCsvDelay          *uint32 `yaml:"min-csv-delay"`
+	MaxCsvDelay          *uint32 `yaml:"max-csv-delay"`
+	MaxChannelReserveSat *uint64 `yaml:"max-channel-reserve-sat"`
+	// CommitmentTypes are the accepted commitment types, all if empty
+	CommitmentTypes []string `yaml:"commitment-types"`
+	// ZeroConf is whether zero-conf requests are granted or rejected
+	ZeroConf string `yaml:"zero-conf"`
+}
+
+// AcceptsCommitmentType returns whether the commitment type is accepted
+func (cc ChannelConstraintsConfig) AcceptsCommitmentType(commitmentType lnrpc.CommitmentType) bool {
+	if len(cc.CommitmentTypes) == 0 {
+		return true
+	}
+	for _, name := range cc.CommitmentTypes {
+		if strings.EqualFold(name, commitmentType.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// ChannelConstraintsPolicy is the channel-constraints section of the config
+// file. Entries under Peers override the constraints for a peer.
+type ChannelConstraintsPolicy struct {
+	ChannelConstraintsConfig `yaml:",inline"`
+	Peers                    []PeerConstraintsConfig `yaml:"peers"`
+}
+
+// PeerConstraintsConfig overrides the channel constraints for a peer. Unset
+// limits are taken from the global constraints.
+type PeerConstraintsConfig struct {
+	Peer                     string `yaml:"peer"`
+	ChannelConstraintsConfig `yaml:",inline"`
+}
+
+// PeerConstraints is a parsed PeerConstraintsConfig
+type PeerConstraints struct {
+	Pubkey      string
+	Constraints ChannelConstraintsConfig
+}
+
+// merge returns the constraints with the limits that o sets replaced
+func (cc ChannelConstraintsConfig) merge(o ChannelConstraintsConfig) ChannelConstraintsConfig {
+	if o.MinFundingSat != nil {
+		cc.MinFundingSat = o.MinFundingSat
+	}
+	if o.MaxFundingSat != nil {
+		cc.MaxFundingSat = o.MaxFundingSat
+	}
+	if o.MaxPushMsat != nil {
+		cc.MaxPushMsat = o.MaxPushMsat
+	}
+	if o.ChannelFlags != "" {
+		cc.ChannelFlags = o.ChannelFlags
+	}
+	if o.MinCsvDelay != nil {
+		cc.MinCsvDelay = o.MinCsvDelay
+	}
+	if o.MaxCsvDelay != nil {
+		cc.MaxCsvDelay = o.MaxCsvDelay
+	}
+	if o.MaxChannelReserveSat != nil {
+		cc.MaxChannelReserveSat = o.MaxChannelReserveSat
+	}
+	if len(o.CommitmentTypes) > 0 {
+		cc.CommitmentTypes = o.CommitmentTypes
+	}
+	if o.ZeroConf != "" {
+		cc.ZeroConf = o.ZeroConf
+	}
+	return cc
+}
+
+func (cc ChannelConstraintsConfig) check() error {
+	switch cc.ChannelFlags {
+	case "", ChannelFlagsPublic, ChannelFlagsPrivate:
+	default:
+		return fmt.Errorf("invalid channel-flags %q: expected %s or %s", cc.ChannelFlags, ChannelFlagsPublic, ChannelFlagsPrivate)
+	}
+	switch cc.ZeroConf {
+	case "", ZeroConfReject, ZeroConfGrant:
+	default:
+		return fmt.Errorf("invalid zero-conf %q: expected %s or %s", cc.ZeroConf, ZeroConfGrant, ZeroConfReject)
+	}
+	for _, name := range cc.CommitmentTypes {
+		if _, ok := lnrpc.CommitmentType_value[strings.ToUpper(name)]; !ok {
+			return fmt.Errorf("invalid commitment type %q", name)
+		}
+	}
+	if cc.MinFundingSat != nil && cc.MaxFundingSat != nil && *cc.MinFundingSat > *cc.MaxFundingSat {
+		return fmt.Errorf("min-funding-sat is above max-funding-sat")
+	}
+	if cc.MinCsvDelay != nil && cc.MaxCsvDelay != nil && *cc.MinCsvDelay > *cc.MaxCsvDelay {
+		return fmt.Errorf("min-csv-delay is above max-csv-delay")
+	}
+	return nil
+}
+
+// ChannelConstraints returns the channel constraints for the peer with the
+// hex encoded pubkey. The first matching peer entry applies.
+func (c *Config) ChannelConstraints(pubkey string) ChannelConstraintsConfig {
+	for _, p := range c.PeerConstraints {
+		if p.Pubkey == pubkey {
+			return c.ChannelConstraintsPolicy.ChannelConstraintsConfig.merge(p.Constraints)
+		}
+	}
+	return c.ChannelConstraintsPolicy.ChannelConstraintsConfig
+}
+
+// checkChannelConstraints parses the channel-constraints section
+func (c *Config) checkChannelConstraints() []error {
+	var errs []error
+	globalErr := c.ChannelConstraintsPolicy.check()
+	if globalErr != nil {
+		errs = append(errs, fmt.Errorf("channel-constraints: %w", globalErr))
+	}
+	c.PeerConstraints = nil
+	for i, pc := range c.ChannelConstraintsPolicy.Peers {
+		peer, err := ParsePeerMatch(pc.Peer)
+		if err == nil && peer.Pubkey == "" {
+			err = fmt.Errorf("expected a pubkey, not a wildcard")
+		}
+		if err == nil {
+			err = pc.ChannelConstraintsConfig.check()
+		}
+		// limits of the peer can contradict the global ones
+		if err == nil && globalErr == nil {
+			err = c.ChannelConstraintsPolicy.merge(pc.ChannelConstraintsConfig).check()
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("channel-constraints: peers entry %d: %w", i+1, err))
+			continue
+		}
+		c.PeerConstraints = append(c.PeerConstraints, PeerConstraints{Pubkey: peer.Pubkey, Constraints: pc.ChannelConstraintsConfig})
+	}
+	return errs
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+// failureCodes are the failure codes that the HTLC interceptor of LND accepts
+// for failed HTLCs. LND fills in the message data of the failure. Other BOLT 4
+// codes like UNKNOWN_NEXT_PEER or FEE_INSUFFICIENT would need an onion
+// encrypted failure message, which only LND can create.
+var failureCodes = []lnrpc.Failure_FailureCode{
+	lnrpc.Failure_TEMPORARY_CHANNEL_FAILURE,
+	lnrpc.Failure_INVALID_ONION_HMAC,
+	lnrpc.Failure_INVALID_ONION_KEY,
+	lnrpc.Failure_INVALID_ONION_VERSION,
+}
+
+// ParseFailureCode parses the name of a failure code like
+// TEMPORARY_CHANNEL_FAILURE
+func ParseFailureCode(s string) (lnrpc.Failure_FailureCode, error) {
+	var names []string
+	for _, code := range failureCodes {
+		if code.String() == strings.ToUpper(s) {
+			return code, nil
+		}
+		names = append(names, code.String())
+	}
+	if _, ok := lnrpc.Failure_FailureCode_value[strings.ToUpper(s)]; ok {
+		return 0, fmt.Errorf("failure code %s is not supported by the LND HTLC interceptor, expected one of %s", strings.ToUpper(s), strings.Join(names, ", "))
+	}
+	return 0, fmt.Errorf("invalid failure code %q: expected one of %s", s, strings.Join(names, ", "))
+}
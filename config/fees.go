@@ -0,0 +1,97 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+// FeePolicyConfig is the forward-fee-policy section of the config file
+type FeePolicyConfig struct {
+	MinFeeMsat  uint64              `yaml:"min-fee-msat"`
+	MinFeePpm   uint64              `yaml:"min-fee-ppm"`
+	Channels    []FeeOverrideConfig `yaml:"channels"`
+	FailureCode string              `yaml:"failure-code"`
+}
+
+// FeeOverrideConfig overrides the minimum fees for an outgoing channel or a
+// channel pair. Unset minimums are taken from the global policy.
+type FeeOverrideConfig struct {
+	Channel    string  `yaml:"channel"`
+	Pair       string  `yaml:"pair"`
+	MinFeeMsat *uint64 `yaml:"min-fee-msat"`
+	MinFeePpm  *uint64 `yaml:"min-fee-ppm"`
+}
+
+// FeeOverride is a parsed FeeOverrideConfig
+type FeeOverride struct {
+	Channels   ChannelPairMatch
+	MinFeeMsat *uint64
+	MinFeePpm  *uint64
+}
+
+// ParseFeeOverride parses a forward-fee-policy channels entry
+func ParseFeeOverride(oc FeeOverrideConfig) (FeeOverride, error) {
+	channels, err := parseOverrideMatch(oc.Channel, oc.Pair)
+	if err != nil {
+		return FeeOverride{}, err
+	}
+	return FeeOverride{Channels: channels, MinFeeMsat: oc.MinFeeMsat, MinFeePpm: oc.MinFeePpm}, nil
+}
+
+// parseOverrideMatch parses the outgoing channel or the channel pair that a
+// per channel override applies to
+func parseOverrideMatch(channel, pair string) (ChannelPairMatch, error) {
+	switch {
+	case channel != "" && pair != "":
+		return ChannelPairMatch{}, fmt.Errorf("expected either channel or pair, not both")
+	case channel != "":
+		out, err := ParseShortChannelID(channel)
+		return ChannelPairMatch{Out: out}, err
+	case pair != "":
+		return ParseChannelPairMatch(pair)
+	}
+	return ChannelPairMatch{}, fmt.Errorf("expected an outgoing channel or a pair IN->OUT")
+}
+
+// MinFees returns the minimum fee and fee rate for a forward from channel in
+// to channel out. The first matching override applies.
+func (c *Config) MinFees(in, out uint64) (uint64, uint64) {
+	minMsat, minPpm := c.ForwardFeePolicy.MinFeeMsat, c.ForwardFeePolicy.MinFeePpm
+	for _, o := range c.FeeOverrides {
+		if !o.Channels.Matches(in, out) {
+			continue
+		}
+		if o.MinFeeMsat != nil {
+			minMsat = *o.MinFeeMsat
+		}
+		if o.MinFeePpm != nil {
+			minPpm = *o.MinFeePpm
+		}
+		break
+	}
+	return minMsat, minPpm
+}
+
+// checkFeePolicy parses the forward-fee-policy section
+func (c *Config) checkFeePolicy() []error {
+	var errs []error
+	c.FeeOverrides = nil
+	for i, oc := range c.ForwardFeePolicy.Channels {
+		o, err := ParseFeeOverride(oc)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("forward-fee-policy: channels entry %d: %w", i+1, err))
+			continue
+		}
+		c.FeeOverrides = append(c.FeeOverrides, o)
+	}
+	if len(c.ForwardFeePolicy.FailureCode) == 0 {
+		c.ForwardFeePolicy.FailureCode = lnrpc.Failure_TEMPORARY_CHANNEL_FAILURE.String()
+	}
+	var err error
+	c.FeeFailure, err = ParseFailureCode(c.ForwardFeePolicy.FailureCode)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("forward-fee-policy: failure-code: %w", err))
+	}
+	return errs
+}
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+// flow actions
+const (
+	FlowAllow  = "allow"
+	FlowDeny   = "deny"
+	FlowMinFee = "min-fee"
+)
+
+// FlowPolicyConfig is the forward-flow-policy section of the config file
+type FlowPolicyConfig struct {
+	SamePeer      FlowActionConfig    `yaml:"same-peer"`
+	Groups        map[string][]string `yaml:"groups"`
+	BetweenGroups []GroupFlowConfig   `yaml:"between-groups"`
+	FailureCode   string              `yaml:"failure-code"`
+}
+
+// FlowActionConfig decides what happens to a forward. With the action
+// min-fee, it is only allowed if its fee rate is at least MinFeePpm.
+type FlowActionConfig struct {
+	Action    string `yaml:"action"`
+	MinFeePpm uint64 `yaml:"min-fee-ppm"`
+}
+
+// GroupFlowConfig is the action for forwards from a channel of the group
+// From to a channel of the group To
+type GroupFlowConfig struct {
+	From      string `yaml:"from"`
+	To        string `yaml:"to"`
+	Action    string `yaml:"action"`
+	MinFeePpm uint64 `yaml:"min-fee-ppm"`
+}
+
+// FlowAction is a parsed FlowActionConfig
+type FlowAction struct {
+	Action    string
+	MinFeePpm uint64
+}
+
+// ParseFlowAction parses an action of the forward-flow-policy. The empty
+// action allows.
+func ParseFlowAction(ac FlowActionConfig) (FlowAction, error) {
+	switch ac.Action {
+	case "", FlowAllow, FlowDeny:
+		if ac.MinFeePpm > 0 {
+			return FlowAction{}, fmt.Errorf("min-fee-ppm is only used with action %s", FlowMinFee)
+		}
+		if ac.Action == "" {
+			ac.Action = FlowAllow
+		}
+	case FlowMinFee:
+		if ac.MinFeePpm == 0 {
+			return FlowAction{}, fmt.Errorf("action %s needs min-fee-ppm", FlowMinFee)
+		}
+	default:
+		return FlowAction{}, fmt.Errorf("invalid action %q: expected %s, %s or %s", ac.Action, FlowAllow, FlowDeny, FlowMinFee)
+	}
+	return FlowAction(ac), nil
+}
+
+// Allows returns whether a forward with the fee rate feePpm is allowed
+func (a FlowAction) Allows(feePpm int64) bool {
+	switch a.Action {
+	case FlowDeny:
+		return false
+	case FlowMinFee:
+		return feePpm >= int64(a.MinFeePpm)
+	}
+	return true
+}
+
+func (a FlowAction) String() string {
+	if a.Action == FlowMinFee {
+		return fmt.Sprintf("%s %d ppm", a.Action, a.MinFeePpm)
+	}
+	return a.Action
+}
+
+// GroupFlow is a parsed GroupFlowConfig
+type GroupFlow struct {
+	From, To       string
+	FromIDs, ToIDs map[uint64]bool
+	Action         FlowAction
+}
+
+// Matches returns whether a forward from channel in to channel out goes from
+// the group From to the group To
+func (g GroupFlow) Matches(in, out uint64) bool {
+	return g.FromIDs[in] && g.ToIDs[out]
+}
+
+func (g GroupFlow) String() string {
+	return fmt.Sprintf("%s->%s", g.From, g.To)
+}
+
+// parseChannelGroups parses the short channel IDs of the channel groups
+func parseChannelGroups(groups map[string][]string) (map[string]map[uint64]bool, []error) {
+	var errs []error
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	// report errors in a stable order
+	sort.Strings(names)
+	parsed := make(map[string]map[uint64]bool, len(groups))
+	for _, name := range names {
+		ids := map[uint64]bool{}
+		for i, s := range groups[name] {
+			id, err := ParseShortChannelID(s)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("forward-flow-policy: groups: %s entry %d: %w", name, i+1, err))
+				continue
+			}
+			ids[id] = true
+		}
+		parsed[name] = ids
+	}
+	return parsed, errs
+}
+
+// checkFlowPolicy parses the forward-flow-policy section
+func (c *Config) checkFlowPolicy() []error {
+	var errs []error
+	var err error
+	c.SamePeerFlow, err = ParseFlowAction(c.ForwardFlowPolicy.SamePeer)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("forward-flow-policy: same-peer: %w", err))
+	}
+	groups, groupErrs := parseChannelGroups(c.ForwardFlowPolicy.Groups)
+	errs = append(errs, groupErrs...)
+	c.GroupFlows = nil
+	for i, gc := range c.ForwardFlowPolicy.BetweenGroups {
+		action, err := ParseFlowAction(FlowActionConfig{Action: gc.Action, MinFeePpm: gc.MinFeePpm})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("forward-flow-policy: between-groups entry %d: %w", i+1, err))
+			continue
+		}
+		from, ok := groups[gc.From]
+		if !ok {
+			errs = append(errs, fmt.Errorf("forward-flow-policy: between-groups entry %d: unknown group %q", i+1, gc.From))
+			continue
+		}
+		to, ok := groups[gc.To]
+		if !ok {
+			errs = append(errs, fmt.Errorf("forward-flow-policy: between-groups entry %d: unknown group %q", i+1, gc.To))
+			continue
+		}
+		c.GroupFlows = append(c.GroupFlows, GroupFlow{From: gc.From, To: gc.To, FromIDs: from, ToIDs: to, Action: action})
+	}
+	if len(c.ForwardFlowPolicy.FailureCode) == 0 {
+		c.ForwardFlowPolicy.FailureCode = lnrpc.Failure_TEMPORARY_CHANNEL_FAILURE.String()
+	}
+	c.FlowFailure, err = ParseFailureCode(c.ForwardFlowPolicy.FailureCode)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("forward-flow-policy: failure-code: %w", err))
+	}
+	return errs
+}
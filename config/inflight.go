@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxHtlcSlots is the maximum number of HTLCs a channel can accept according
+// to BOLT 2 and the default of LND. Slot percentages refer to it if the limit
+// of a channel is not known.
+const MaxHtlcSlots = 483
+
+// InflightLimitsConfig are the caps on HTLCs that are in flight at the same
+// time, as they are written in the config file. A zero cap does not limit.
+type InflightLimitsConfig struct {
+	PeerHtlcs    string `yaml:"peer-htlcs"`
+	PeerMsat     uint64 `yaml:"peer-msat"`
+	ChannelHtlcs string `yaml:"channel-htlcs"`
+	ChannelMsat  uint64 `yaml:"channel-msat"`
+}
+
+// InflightLimits are the parsed caps on in-flight HTLCs per incoming peer and
+// per outgoing channel
+type InflightLimits struct {
+	PeerHtlcs    Slots
+	PeerMsat     uint64
+	ChannelHtlcs Slots
+	ChannelMsat  uint64
+}
+
+// Slots is a number of HTLC slots or a percentage of the slots of a channel.
+// The zero Slots does not limit.
+type Slots struct {
+	Count   int
+	Percent float64
+}
+
+// ParseSlots parses a number of HTLC slots like "100" or a percentage of the
+// slots of a channel like "30%". The empty string does not limit.
+func ParseSlots(s string) (Slots, error) {
+	if s == "" {
+		return Slots{}, nil
+	}
+	if strings.HasSuffix(s, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return Slots{}, fmt.Errorf("invalid slots %q: expected a percentage between 0%% and 100%%", s)
+		}
+		return Slots{Percent: percent}, nil
+	}
+	slots, err := strconv.Atoi(s)
+	if err != nil || slots < 0 || slots > MaxHtlcSlots {
+		return Slots{}, fmt.Errorf("invalid slots %q: expected a number up to %d or a percentage", s, MaxHtlcSlots)
+	}
+	return Slots{Count: slots}, nil
+}
+
+// Of returns the number of slots for a channel that carries limit HTLCs at
+// once. A limit of 0 is not known and percentages refer to MaxHtlcSlots.
+func (s Slots) Of(limit uint32) int {
+	if s.Percent == 0 {
+		return s.Count
+	}
+	if limit == 0 || limit > MaxHtlcSlots {
+		limit = MaxHtlcSlots
+	}
+	// at least one slot
+	return max(1, int(s.Percent*float64(limit)/100))
+}
+
+func (s Slots) String() string {
+	if s.Percent > 0 {
+		return strconv.FormatFloat(s.Percent, 'f', -1, 64) + "%"
+	}
+	return strconv.Itoa(s.Count)
+}
+
+// ParseInflightLimits parses the forward-inflight-limits
+func ParseInflightLimits(lc InflightLimitsConfig) (InflightLimits, error) {
+	peerHtlcs, err := ParseSlots(lc.PeerHtlcs)
+	if err != nil {
+		return InflightLimits{}, fmt.Errorf("peer-htlcs: %w", err)
+	}
+	channelHtlcs, err := ParseSlots(lc.ChannelHtlcs)
+	if err != nil {
+		return InflightLimits{}, fmt.Errorf("channel-htlcs: %w", err)
+	}
+	return InflightLimits{
+		PeerHtlcs:    peerHtlcs,
+		PeerMsat:     lc.PeerMsat,
+		ChannelHtlcs: channelHtlcs,
+		ChannelMsat:  lc.ChannelMsat,
+	}, nil
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+// LiquidityPolicyConfig is the forward-liquidity-policy section of the config
+// file
+type LiquidityPolicyConfig struct {
+	MinLocalBalance string                 `yaml:"min-local-balance"`
+	Channels        []LiquidityFloorConfig `yaml:"channels"`
+	FailureCode     string                 `yaml:"failure-code"`
+}
+
+// LiquidityFloorConfig overrides the local balance floor for an outgoing
+// channel or for all channels with a peer
+type LiquidityFloorConfig struct {
+	Channel         string `yaml:"channel"`
+	Peer            string `yaml:"peer"`
+	MinLocalBalance string `yaml:"min-local-balance"`
+}
+
+// Floor is a minimum local balance in sat or in percent of the capacity of
+// a channel. The zero Floor does not protect the balance.
+type Floor struct {
+	Sat     int64
+	Percent float64
+}
+
+// ParseFloor parses a balance in sat like "500000" or a percentage of the
+// channel capacity like "20%". The empty string does not protect the balance.
+func ParseFloor(s string) (Floor, error) {
+	if s == "" {
+		return Floor{}, nil
+	}
+	if strings.HasSuffix(s, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return Floor{}, fmt.Errorf("invalid balance %q: expected a percentage between 0%% and 100%%", s)
+		}
+		return Floor{Percent: percent}, nil
+	}
+	sat, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || sat < 0 {
+		return Floor{}, fmt.Errorf("invalid balance %q: expected an amount in sat or a percentage", s)
+	}
+	return Floor{Sat: sat}, nil
+}
+
+// Msat returns the floor in msat for a channel of capacity sat
+func (f Floor) Msat(capacity int64) int64 {
+	if f.Percent > 0 {
+		return int64(f.Percent * float64(capacity) * 10)
+	}
+	return f.Sat * 1000
+}
+
+func (f Floor) String() string {
+	if f.Percent > 0 {
+		return strconv.FormatFloat(f.Percent, 'f', -1, 64) + "%"
+	}
+	return fmt.Sprintf("%d sat", f.Sat)
+}
+
+// LiquidityFloor is a parsed LiquidityFloorConfig. It matches either Channel
+// or Peer.
+type LiquidityFloor struct {
+	Channel uint64
+	Peer    string
+	Floor   Floor
+}
+
+// ParseLiquidityFloor parses a forward-liquidity-policy channels entry
+func ParseLiquidityFloor(fc LiquidityFloorConfig) (LiquidityFloor, error) {
+	var f LiquidityFloor
+	var err error
+	switch {
+	case fc.Channel != "" && fc.Peer != "":
+		return LiquidityFloor{}, fmt.Errorf("expected either channel or peer, not both")
+	case fc.Channel != "":
+		f.Channel, err = ParseShortChannelID(fc.Channel)
+	case fc.Peer != "":
+		var peer PeerMatch
+		peer, err = ParsePeerMatch(fc.Peer)
+		if err == nil && peer.Pubkey == "" {
+			err = fmt.Errorf("expected a pubkey, not a wildcard")
+		}
+		f.Peer = peer.Pubkey
+	default:
+		err = fmt.Errorf("expected an outgoing channel or a peer")
+	}
+	if err != nil {
+		return LiquidityFloor{}, err
+	}
+	f.Floor, err = ParseFloor(fc.MinLocalBalance)
+	if err != nil {
+		return LiquidityFloor{}, fmt.Errorf("min-local-balance: %w", err)
+	}
+	return f, nil
+}
+
+// LocalBalanceFloor returns the floor for the outgoing channel out with the
+// peer. The first matching entry applies, otherwise the global floor.
+func (c *Config) LocalBalanceFloor(out uint64, peer string) Floor {
+	for _, f := range c.LiquidityFloors {
+		if f.Channel != 0 && f.Channel == out || f.Peer != "" && f.Peer == peer {
+			return f.Floor
+		}
+	}
+	return c.LiquidityFloor
+}
+
+// checkLiquidityPolicy parses the forward-liquidity-policy section
+func (c *Config) checkLiquidityPolicy() []error {
+	var errs []error
+	var err error
+	c.LiquidityFloor, err = ParseFloor(c.ForwardLiquidityPolicy.MinLocalBalance)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("forward-liquidity-policy: min-local-balance: %w", err))
+	}
+	c.LiquidityFloors = nil
+	for i, fc := range c.ForwardLiquidityPolicy.Channels {
+		f, err := ParseLiquidityFloor(fc)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("forward-liquidity-policy: channels entry %d: %w", i+1, err))
+			continue
+		}
+		c.LiquidityFloors = append(c.LiquidityFloors, f)
+	}
+	if len(c.ForwardLiquidityPolicy.FailureCode) == 0 {
+		c.ForwardLiquidityPolicy.FailureCode = lnrpc.Failure_TEMPORARY_CHANNEL_FAILURE.String()
+	}
+	c.LiquidityFailure, err = ParseFailureCode(c.ForwardLiquidityPolicy.FailureCode)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("forward-liquidity-policy: failure-code: %w", err))
+	}
+	return errs
+}
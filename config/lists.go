@@ -0,0 +1,339 @@
+package config
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"gopkg.in/yaml.v3"
+)
+
+// Wildcard matches any node or channel in a list entry
+const Wildcard = "*"
+
+// PeerMatch is a parsed channel list entry. An empty Pubkey matches any node.
+type PeerMatch struct {
+	Pubkey string
+}
+
+// ParsePeerMatch parses a hex encoded node pubkey or the wildcard
+func ParsePeerMatch(s string) (PeerMatch, error) {
+	if s == Wildcard {
+		return PeerMatch{}, nil
+	}
+	if len(s) != 66 {
+		return PeerMatch{}, fmt.Errorf("invalid pubkey: expected 66 hex characters, got %d", len(s))
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return PeerMatch{}, fmt.Errorf("invalid pubkey: not hex encoded")
+	}
+	if b[0] != 0x02 && b[0] != 0x03 {
+		return PeerMatch{}, fmt.Errorf("invalid pubkey: must start with 02 or 03")
+	}
+	return PeerMatch{Pubkey: strings.ToLower(s)}, nil
+}
+
+// Matches returns whether the hex encoded pubkey matches the entry
+func (m PeerMatch) Matches(pubkey string) bool {
+	return m.Pubkey == "" || m.Pubkey == pubkey
+}
+
+func (m PeerMatch) String() string {
+	if m.Pubkey == "" {
+		return Wildcard
+	}
+	return m.Pubkey
+}
+
+// ParseShortChannelID parses a short channel ID like 700762x1327x1
+func ParseShortChannelID(s string) (uint64, error) {
+	parts := strings.Split(s, "x")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid short channel id %q: expected BLOCKxTXxOUTPUT like 700762x1327x1", s)
+	}
+	block, err := strconv.ParseUint(parts[0], 10, 24)
+	if err != nil {
+		return 0, fmt.Errorf("invalid short channel id %q: block height must be a number below 2^24", s)
+	}
+	tx, err := strconv.ParseUint(parts[1], 10, 24)
+	if err != nil {
+		return 0, fmt.Errorf("invalid short channel id %q: transaction index must be a number below 2^24", s)
+	}
+	output, err := strconv.ParseUint(parts[2], 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid short channel id %q: output index must be a number below 2^16", s)
+	}
+	id := block<<40 | tx<<16 | output
+	if id == 0 {
+		return 0, fmt.Errorf("invalid short channel id %q", s)
+	}
+	return id, nil
+}
+
+// FormatShortChannelID formats a channel ID like 700762x1327x1
+func FormatShortChannelID(id uint64) string {
+	return fmt.Sprintf("%dx%dx%d", id>>40, id>>16&0xffffff, id&0xffff)
+}
+
+// ChannelPairMatch is a parsed forward list entry. A zero channel ID matches
+// any channel.
+type ChannelPairMatch struct {
+	In, Out uint64
+}
+
+// ParseChannelPairMatch parses a forward list entry, which is either a single
+// incoming channel ID, a pair IN->OUT or the wildcard. Both sides of a pair can
+// be the wildcard.
+func ParseChannelPairMatch(s string) (ChannelPairMatch, error) {
+	parts := strings.Split(s, "->")
+	if len(parts) > 2 {
+		return ChannelPairMatch{}, fmt.Errorf("invalid channel pair: expected IN->OUT, got %d parts", len(parts))
+	}
+	var ids [2]uint64
+	for i, part := range parts {
+		if part == Wildcard {
+			continue
+		}
+		id, err := ParseShortChannelID(part)
+		if err != nil {
+			return ChannelPairMatch{}, err
+		}
+		ids[i] = id
+	}
+	return ChannelPairMatch{In: ids[0], Out: ids[1]}, nil
+}
+
+// Matches returns whether a forward from channel in to channel out matches the entry
+func (m ChannelPairMatch) Matches(in, out uint64) bool {
+	return (m.In == 0 || m.In == in) && (m.Out == 0 || m.Out == out)
+}
+
+func (m ChannelPairMatch) String() string {
+	in := Wildcard
+	if m.In != 0 {
+		in = FormatShortChannelID(m.In)
+	}
+	if m.Out == 0 {
+		return in
+	}
+	return in + "->" + FormatShortChannelID(m.Out)
+}
+
+// PeerPairMatch is a parsed forward list entry that matches the peers of a
+// forward instead of its channels
+type PeerPairMatch struct {
+	In, Out PeerMatch
+}
+
+// isPeerPair returns whether a forward list entry names peers instead of
+// channels
+func isPeerPair(s string) bool {
+	for _, part := range strings.Split(s, "->") {
+		if len(part) == 66 {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePeerPairMatch parses a forward list entry of peers, which is either a
+// single incoming peer pubkey or a pair IN->OUT. Both sides of a pair can be
+// the wildcard.
+func ParsePeerPairMatch(s string) (PeerPairMatch, error) {
+	parts := strings.Split(s, "->")
+	if len(parts) > 2 {
+		return PeerPairMatch{}, fmt.Errorf("invalid peer pair: expected IN->OUT, got %d parts", len(parts))
+	}
+	var peers [2]PeerMatch
+	for i, part := range parts {
+		peer, err := ParsePeerMatch(part)
+		if err != nil {
+			return PeerPairMatch{}, err
+		}
+		peers[i] = peer
+	}
+	return PeerPairMatch{In: peers[0], Out: peers[1]}, nil
+}
+
+// Matches returns whether a forward from peer in to peer out matches the entry
+func (m PeerPairMatch) Matches(in, out string) bool {
+	return m.In.Matches(in) && m.Out.Matches(out)
+}
+
+func (m PeerPairMatch) String() string {
+	if m.Out.Pubkey == "" {
+		return m.In.String()
+	}
+	return m.In.String() + "->" + m.Out.String()
+}
+
+// listLines returns the line numbers of the entries of all top level lists in
+// the yaml file at path
+func listLines(path string) map[string][]int {
+	lines := map[string][]int{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return lines
+	}
+	var root yaml.Node
+	if yaml.Unmarshal(data, &root) != nil || len(root.Content) == 0 {
+		return lines
+	}
+	mapping := root.Content[0]
+	if mapping.Kind != yaml.MappingNode {
+		return lines
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		key, value := mapping.Content[i], mapping.Content[i+1]
+		if value.Kind != yaml.SequenceNode {
+			continue
+		}
+		for _, entry := range value.Content {
+			lines[key.Value] = append(lines[key.Value], entry.Line)
+		}
+	}
+	return lines
+}
+
+// entryPos names entry i of the list key with n entries, with the line of the
+// entry in the config file if it is known
+func (c *Config) entryPos(key string, i, n int) string {
+	if lines := c.lines[key]; len(lines) == n {
+		return fmt.Sprintf("%s:%d: %s entry", c.source, lines[i], key)
+	}
+	return fmt.Sprintf("%s entry %d", key, i+1)
+}
+
+// parseList parses every entry of the list key with parse and reports
+// problems with the line of the entry in the config file if it is known
+func parseList[T any](c *Config, key string, entries []string, parse func(string) (T, error)) ([]T, error) {
+	var errs []error
+	parsed := make([]T, 0, len(entries))
+	for i, entry := range entries {
+		p, err := parse(strings.TrimSpace(entry))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %q: %w", c.entryPos(key, i, len(entries)), entry, err))
+			continue
+		}
+		parsed = append(parsed, p)
+	}
+	return parsed, errors.Join(errs...)
+}
+
+// ChannelRule is a parsed channel-chain entry like "deny 02ab..."
+type ChannelRule struct {
+	Accept bool
+	Peer   PeerMatch
+}
+
+// ForwardRule is a parsed forward-chain entry like "allow *->25328x256x0",
+// "deny 02ab..." or "deny 25328x256x0 INVALID_ONION_VERSION". An entry
+// matches either channels or peers, the other match is the wildcard. A zero
+// FailureCode means that the configured default is used for denied HTLCs.
+type ForwardRule struct {
+	Accept      bool
+	Channels    ChannelPairMatch
+	Peers       PeerPairMatch
+	FailureCode lnrpc.Failure_FailureCode
+	entry       string
+}
+
+// Matches returns whether a forward from channel in of peer peerIn to channel
+// out of peer peerOut matches the rule
+func (r ForwardRule) Matches(in, out uint64, peerIn, peerOut string) bool {
+	return r.Channels.Matches(in, out) && r.Peers.Matches(peerIn, peerOut)
+}
+
+func (r ChannelRule) String() string {
+	return actionString(r.Accept) + " " + r.Peer.String()
+}
+
+func (r ForwardRule) String() string {
+	if r.FailureCode != 0 {
+		return actionString(r.Accept) + " " + r.entry + " " + r.FailureCode.String()
+	}
+	return actionString(r.Accept) + " " + r.entry
+}
+
+func actionString(accept bool) string {
+	if accept {
+		return "allow"
+	}
+	return "deny"
+}
+
+// parseAction parses "allow" or "deny"
+func parseAction(s string) (bool, error) {
+	switch s {
+	case "allow":
+		return true, nil
+	case "deny":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid action %q: expected allow or deny", s)
+}
+
+// splitRule splits a chain entry into its action, the match and the fields
+// that follow the match
+func splitRule(s string) (bool, string, []string, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 2 {
+		return false, "", nil, fmt.Errorf("expected ACTION MATCH like \"deny *\", got %d fields", len(fields))
+	}
+	accept, err := parseAction(fields[0])
+	return accept, fields[1], fields[2:], err
+}
+
+// ParseChannelRule parses a channel-chain entry
+func ParseChannelRule(s string) (ChannelRule, error) {
+	accept, match, rest, err := splitRule(s)
+	if err != nil {
+		return ChannelRule{}, err
+	}
+	if len(rest) > 0 {
+		return ChannelRule{}, fmt.Errorf("expected ACTION MATCH like \"deny *\", got %d fields", 2+len(rest))
+	}
+	peer, err := ParsePeerMatch(match)
+	return ChannelRule{Accept: accept, Peer: peer}, err
+}
+
+// ParseForwardRule parses a forward-chain entry. A deny rule can be followed
+// by the failure code for the HTLCs it denies.
+func ParseForwardRule(s string) (ForwardRule, error) {
+	accept, match, rest, err := splitRule(s)
+	if err != nil {
+		return ForwardRule{}, err
+	}
+	if len(rest) > 1 {
+		return ForwardRule{}, fmt.Errorf("expected ACTION MATCH [FAILURE_CODE] like \"deny * TEMPORARY_CHANNEL_FAILURE\", got %d fields", 2+len(rest))
+	}
+	rule := ForwardRule{Accept: accept, entry: match}
+	if isPeerPair(match) {
+		rule.Peers, err = ParsePeerPairMatch(match)
+	} else {
+		rule.Channels, err = ParseChannelPairMatch(match)
+	}
+	if err != nil {
+		return ForwardRule{}, err
+	}
+	if len(rest) == 1 {
+		if accept {
+			return ForwardRule{}, fmt.Errorf("a failure code can only follow a deny rule")
+		}
+		rule.FailureCode, err = ParseFailureCode(rest[0])
+	}
+	return rule, err
+}
+
+// listEntry parses the entries of an allowlist or denylist as chain rules
+// with the given action
+func listEntry[T any](action string, parse func(string) (T, error)) func(string) (T, error) {
+	return func(s string) (T, error) {
+		return parse(action + " " + s)
+	}
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"fmt"
+)
+
+// ChannelRateLimitConfig is the channel-rate-limit section of the config
+// file. It limits the channel open requests per peer and from all peers
+// within a sliding window of Window seconds. A zero limit does not limit. A
+// peer that exceeds its limit DenylistAfter times within the window is denied
+// for DenylistDuration seconds. A zero DenylistAfter never denies a peer.
+type ChannelRateLimitConfig struct {
+	PerPeer          int `yaml:"per-peer"`
+	Global           int `yaml:"global"`
+	Window           int `yaml:"window"`
+	DenylistAfter    int `yaml:"denylist-after"`
+	DenylistDuration int `yaml:"denylist-duration"`
+}
+
+// checkChannelRateLimit checks the channel-rate-limit section
+func (c *Config) checkChannelRateLimit() []error {
+	var errs []error
+	rl := &c.ChannelRateLimit
+	if rl.PerPeer < 0 || rl.Global < 0 || rl.Window < 0 || rl.DenylistAfter < 0 || rl.DenylistDuration < 0 {
+		errs = append(errs, fmt.Errorf("channel-rate-limit: values must not be negative"))
+	}
+	if rl.DenylistAfter > 0 && rl.PerPeer == 0 {
+		errs = append(errs, fmt.Errorf("channel-rate-limit: denylist-after needs per-peer"))
+	}
+	if rl.Window == 0 {
+		rl.Window = 600
+	}
+	if rl.DenylistDuration == 0 {
+		rl.DenylistDuration = 86400
+	}
+	return errs
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+// MaxCsvDelay is the largest CSV delay that is sent back for a channel. It is
+// the default maximum of Core Lightning, LND accepts up to 10000 blocks.
+const MaxCsvDelay = 2016
+
+// chainParams returns the chain parameters of the network
+func chainParams(network string) *chaincfg.Params {
+	switch network {
+	case "testnet":
+		return &chaincfg.TestNet3Params
+	case "signet":
+		return &chaincfg.SigNetParams
+	case "regtest":
+		return &chaincfg.RegressionNetParams
+	case "simnet":
+		return &chaincfg.SimNetParams
+	}
+	return &chaincfg.MainNetParams
+}
+
+// CheckShutdownAddress returns an error if addr is not an address on the
+// network
+func CheckShutdownAddress(addr, network string) error {
+	params := chainParams(network)
+	decoded, err := btcutil.DecodeAddress(addr, params)
+	if err != nil {
+		return fmt.Errorf("invalid upfront shutdown address %q: %w", addr, err)
+	}
+	if !decoded.IsForNet(params) {
+		return fmt.Errorf("upfront shutdown address %q is not a %s address", addr, network)
+	}
+	return nil
+}
+
+// ChannelParamsConfig are the channel parameters that are sent back when a
+// channel is accepted. Unset parameters are taken from the request.
+type ChannelParamsConfig struct {
+	CsvDelay        *uint32 `yaml:"csv-delay"`
+	MaxHtlcCount    *uint32 `yaml:"max-htlc-count"`
+	ReserveSat      *uint64 `yaml:"reserve-sat"`
+	InFlightMaxMsat *uint64 `yaml:"in-flight-max-msat"`
+	MinHtlcIn       *uint64 `yaml:"min-htlc-in"`
+	UpfrontShutdown string  `yaml:"upfront-shutdown"`
+}
+
+// ChannelParamsPolicy is the channel-accept-params section of the config
+// file. The first tier with a matching peer overrides the parameters.
+type ChannelParamsPolicy struct {
+	ChannelParamsConfig `yaml:",inline"`
+	Tiers               []ChannelParamsTierConfig `yaml:"tiers"`
+}
+
+// ChannelParamsTierConfig overrides the channel parameters for its peers
+type ChannelParamsTierConfig struct {
+	Name                string   `yaml:"name"`
+	Peers               []string `yaml:"peers"`
+	ChannelParamsConfig `yaml:",inline"`
+}
+
+// ChannelParamsTier is a parsed ChannelParamsTierConfig
+type ChannelParamsTier struct {
+	Name   string
+	Peers  []PeerMatch
+	Params ChannelParamsConfig
+}
+
+// merge returns the parameters with the ones that o sets replaced
+func (pc ChannelParamsConfig) merge(o ChannelParamsConfig) ChannelParamsConfig {
+	if o.CsvDelay != nil {
+		pc.CsvDelay = o.CsvDelay
+	}
+	if o.MaxHtlcCount != nil {
+		pc.MaxHtlcCount = o.MaxHtlcCount
+	}
+	if o.ReserveSat != nil {
+		pc.ReserveSat = o.ReserveSat
+	}
+	if o.InFlightMaxMsat != nil {
+		pc.InFlightMaxMsat = o.InFlightMaxMsat
+	}
+	if o.MinHtlcIn != nil {
+		pc.MinHtlcIn = o.MinHtlcIn
+	}
+	if o.UpfrontShutdown != "" {
+		pc.UpfrontShutdown = o.UpfrontShutdown
+	}
+	return pc
+}
+
+// check returns an error for the first parameter that is out of bounds
+func (pc ChannelParamsConfig) check(network string) error {
+	if pc.CsvDelay != nil && *pc.CsvDelay > MaxCsvDelay {
+		return fmt.Errorf("csv-delay %d is above %d blocks", *pc.CsvDelay, MaxCsvDelay)
+	}
+	if pc.MaxHtlcCount != nil && *pc.MaxHtlcCount > MaxHtlcSlots {
+		return fmt.Errorf("max-htlc-count %d is above %d", *pc.MaxHtlcCount, MaxHtlcSlots)
+	}
+	if pc.UpfrontShutdown != "" {
+		return CheckShutdownAddress(pc.UpfrontShutdown, network)
+	}
+	return nil
+}
+
+// ChannelParams returns the name of the tier of the peer with the hex encoded
+// pubkey, or "" if it is in no tier, and its channel parameters
+func (c *Config) ChannelParams(pubkey string) (string, ChannelParamsConfig) {
+	for _, tier := range c.ChannelParamsTiers {
+		for _, peer := range tier.Peers {
+			if peer.Matches(pubkey) {
+				return tier.Name, c.ChannelParamsPolicy.ChannelParamsConfig.merge(tier.Params)
+			}
+		}
+	}
+	return "", c.ChannelParamsPolicy.ChannelParamsConfig
+}
+
+// checkChannelParams parses the channel-accept-params section
+func (c *Config) checkChannelParams() []error {
+	var errs []error
+	if err := c.ChannelParamsPolicy.check(c.Network); err != nil {
+		errs = append(errs, fmt.Errorf("channel-accept-params: %w", err))
+	}
+	c.ChannelParamsTiers = nil
+	for i, tc := range c.ChannelParamsPolicy.Tiers {
+		if tc.Name == "" {
+			errs = append(errs, fmt.Errorf("channel-accept-params: tiers entry %d: missing name", i+1))
+			continue
+		}
+		if err := tc.ChannelParamsConfig.check(c.Network); err != nil {
+			errs = append(errs, fmt.Errorf("channel-accept-params: tier %s: %w", tc.Name, err))
+			continue
+		}
+		tier := ChannelParamsTier{Name: tc.Name, Params: tc.ChannelParamsConfig}
+		for j, s := range tc.Peers {
+			peer, err := ParsePeerMatch(s)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("channel-accept-params: tier %s: peers entry %d: %w", tc.Name, j+1, err))
+				continue
+			}
+			tier.Peers = append(tier.Peers, peer)
+		}
+		c.ChannelParamsTiers = append(c.ChannelParamsTiers, tier)
+	}
+	return errs
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// rate limit scopes
+const (
+	ScopeChannel = "channel"
+	ScopePeer    = "peer"
+	ScopePair    = "pair"
+)
+
+// RateLimitConfig is a forward-rate-limits entry as it is written in the
+// config file
+type RateLimitConfig struct {
+	Scope          string  `yaml:"scope"`
+	Match          string  `yaml:"match"`
+	HtlcsPerSecond float64 `yaml:"htlcs-per-second"`
+	HtlcBurst      float64 `yaml:"htlc-burst"`
+	MsatPerMinute  float64 `yaml:"msat-per-minute"`
+}
+
+// RateLimit is a parsed forward rate limit. Every incoming channel, incoming
+// peer or channel pair that it matches has its own token buckets. A zero rate
+// does not limit.
+type RateLimit struct {
+	Scope          string
+	Peer           PeerMatch
+	Channels       ChannelPairMatch
+	HtlcsPerSecond float64
+	HtlcBurst      float64
+	MsatPerMinute  float64
+}
+
+// ParseRateLimit checks a forward-rate-limits entry
+func ParseRateLimit(rc RateLimitConfig) (RateLimit, error) {
+	l := RateLimit{
+		Scope:          rc.Scope,
+		HtlcsPerSecond: rc.HtlcsPerSecond,
+		HtlcBurst:      rc.HtlcBurst,
+		MsatPerMinute:  rc.MsatPerMinute,
+	}
+	if len(rc.Match) == 0 {
+		rc.Match = Wildcard
+	}
+	var err error
+	switch rc.Scope {
+	case ScopeChannel:
+		l.Channels, err = ParseChannelPairMatch(rc.Match)
+		if err == nil && strings.Contains(rc.Match, "->") {
+			err = fmt.Errorf("a channel limit matches the incoming channel, use scope pair for IN->OUT")
+		}
+	case ScopePeer:
+		l.Peer, err = ParsePeerMatch(rc.Match)
+	case ScopePair:
+		l.Channels, err = ParseChannelPairMatch(rc.Match)
+	default:
+		err = fmt.Errorf("invalid scope %q: expected channel, peer or pair", rc.Scope)
+	}
+	if err != nil {
+		return RateLimit{}, err
+	}
+	if l.HtlcsPerSecond < 0 || l.HtlcBurst < 0 || l.MsatPerMinute < 0 {
+		return RateLimit{}, fmt.Errorf("rates must not be negative")
+	}
+	if l.HtlcsPerSecond == 0 && l.MsatPerMinute == 0 {
+		return RateLimit{}, fmt.Errorf("expected htlcs-per-second or msat-per-minute")
+	}
+	// by default, a second worth of HTLCs can be forwarded at once
+	if l.HtlcBurst == 0 {
+		l.HtlcBurst = l.HtlcsPerSecond
+	}
+	if l.HtlcBurst < 1 {
+		l.HtlcBurst = 1
+	}
+	return l, nil
+}
+
+// Subject returns which token buckets of the limit an HTLC from the incoming
+// channel in and peer to the outgoing channel out uses, or false if the limit
+// does not apply to it
+func (l RateLimit) Subject(in, out uint64, peer string) (string, bool) {
+	switch l.Scope {
+	case ScopeChannel:
+		return fmt.Sprintf("channel %d", in), l.Channels.Matches(in, 0)
+	case ScopePeer:
+		return "peer " + peer, l.Peer.Matches(peer)
+	default:
+		return fmt.Sprintf("pair %d->%d", in, out), l.Channels.Matches(in, out)
+	}
+}
+
+func (l RateLimit) String() string {
+	match := l.Peer.String()
+	if l.Scope != ScopePeer {
+		match = l.Channels.String()
+	}
+	var rates []string
+	if l.HtlcsPerSecond > 0 {
+		rates = append(rates, fmt.Sprintf("%g htlc/s (burst %g)", l.HtlcsPerSecond, l.HtlcBurst))
+	}
+	if l.MsatPerMinute > 0 {
+		rates = append(rates, fmt.Sprintf("%g msat/min", l.MsatPerMinute))
+	}
+	return fmt.Sprintf("%s %s: %s", l.Scope, match, strings.Join(rates, ", "))
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+// MinCustomRecordType is the first TLV type that can be used for custom
+// records
+const MinCustomRecordType = 65536
+
+// CustomRecordsConfig is the forward-custom-records section of the config
+// file. Forwards with a record of a type in Deny, a record of a type that is
+// not in a non-empty Allow or records larger than the size limits are
+// denied. A zero size does not limit.
+type CustomRecordsConfig struct {
+	Allow         []uint64 `yaml:"allow"`
+	Deny          []uint64 `yaml:"deny"`
+	MaxRecordSize int      `yaml:"max-record-size"`
+	MaxTotalSize  int      `yaml:"max-total-size"`
+	FailureCode   string   `yaml:"failure-code"`
+}
+
+// CheckRecord returns whether a record of type recordType with size bytes is
+// allowed and why not if it is not
+func (rc CustomRecordsConfig) CheckRecord(recordType uint64, size int) (string, bool) {
+	if slices.Contains(rc.Deny, recordType) {
+		return fmt.Sprintf("record type %d denied", recordType), false
+	}
+	if len(rc.Allow) > 0 && !slices.Contains(rc.Allow, recordType) {
+		return fmt.Sprintf("record type %d not allowed", recordType), false
+	}
+	if rc.MaxRecordSize > 0 && size > rc.MaxRecordSize {
+		return fmt.Sprintf("record type %d has %d bytes, more than %d", recordType, size, rc.MaxRecordSize), false
+	}
+	return "", true
+}
+
+// checkCustomRecords checks the forward-custom-records section
+func (c *Config) checkCustomRecords() []error {
+	var errs []error
+	checkTypes := func(key string, recordTypes []uint64) {
+		for i, recordType := range recordTypes {
+			if recordType < MinCustomRecordType {
+				errs = append(errs, fmt.Errorf("forward-custom-records: %s entry %d: type %d is below %d", key, i+1, recordType, MinCustomRecordType))
+			}
+		}
+	}
+	checkTypes("allow", c.ForwardCustomRecords.Allow)
+	checkTypes("deny", c.ForwardCustomRecords.Deny)
+	if c.ForwardCustomRecords.MaxRecordSize < 0 || c.ForwardCustomRecords.MaxTotalSize < 0 {
+		errs = append(errs, fmt.Errorf("forward-custom-records: sizes must not be negative"))
+	}
+	if len(c.ForwardCustomRecords.FailureCode) == 0 {
+		c.ForwardCustomRecords.FailureCode = lnrpc.Failure_TEMPORARY_CHANNEL_FAILURE.String()
+	}
+	var err error
+	c.CustomRecordsFailure, err = ParseFailureCode(c.ForwardCustomRecords.FailureCode)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("forward-custom-records: failure-code: %w", err))
+	}
+	return errs
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// ReputationConfig configures how incoming peers are scored by the outcome of
+// their HTLCs and when peers with a low score are forwarded
+type ReputationConfig struct {
+	// File keeps the scores across restarts. A relative path is relative
+	// to the directory of the configuration file.
+	File string `yaml:"file"`
+	// HalfLifeHours is after how long an outcome counts half
+	HalfLifeHours float64 `yaml:"half-life-hours"`
+	// SlowHoldSeconds is how long an HTLC can be held before it counts
+	// against the peer
+	SlowHoldSeconds float64 `yaml:"slow-hold-seconds"`
+	// MinScore is the score below which a peer has a low reputation. Zero
+	// forwards for all peers.
+	MinScore float64 `yaml:"min-score"`
+	// peers with a low reputation are only forwarded while the outgoing
+	// channel has fewer HTLCs and less msat in flight
+	MaxChannelHtlcs string `yaml:"max-channel-htlcs"`
+	MaxChannelMsat  uint64 `yaml:"max-channel-msat"`
+	// MinLocalBalance is the local balance in sat or in percent of the
+	// capacity that the outgoing channel must keep after the HTLC
+	MinLocalBalance string `yaml:"min-local-balance"`
+	FailureCode     string `yaml:"failure-code"`
+}
+
+// checkReputation fills in the defaults of the forward-reputation section
+// and parses its slot cap, balance floor and failure code
+func (c *Config) checkReputation() []error {
+	var errs []error
+	r := &c.ForwardReputation
+	if len(r.File) == 0 {
+		r.File = "reputation.json"
+	}
+	if !filepath.IsAbs(r.File) {
+		r.File = filepath.Join(filepath.Dir(c.source), r.File)
+	}
+	if r.HalfLifeHours <= 0 {
+		r.HalfLifeHours = 168
+	}
+	if r.SlowHoldSeconds <= 0 {
+		r.SlowHoldSeconds = 30
+	}
+	if r.MinScore < 0 || r.MinScore > 1 {
+		errs = append(errs, fmt.Errorf("forward-reputation: min-score must be between 0 and 1"))
+	}
+	if len(r.MaxChannelHtlcs) == 0 {
+		r.MaxChannelHtlcs = "10%"
+	}
+	var err error
+	c.ReputationMaxChannelHtlcs, err = ParseSlots(r.MaxChannelHtlcs)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("forward-reputation: max-channel-htlcs: %w", err))
+	}
+	if len(r.MinLocalBalance) == 0 {
+		r.MinLocalBalance = "50%"
+	}
+	c.ReputationMinLocalBalance, err = ParseFloor(r.MinLocalBalance)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("forward-reputation: min-local-balance: %w", err))
+	}
+	if len(r.FailureCode) == 0 {
+		r.FailureCode = "TEMPORARY_CHANNEL_FAILURE"
+	}
+	c.ReputationFailure, err = ParseFailureCode(r.FailureCode)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("forward-reputation: failure-code: %w", err))
+	}
+	return errs
+}
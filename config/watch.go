@@ -0,0 +1,50 @@
+package config
+
+import (
+	"context"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// WatchInterval is how often watched files are checked for changes
+var WatchInterval = 2 * time.Second
+
+// WatchFile polls path and calls onChange whenever its modification time or
+// size changes. It returns when ctx is done.
+func WatchFile(ctx context.Context, path string, onChange func()) {
+	var lastMod time.Time
+	var lastSize int64
+	if info, err := os.Stat(path); err == nil {
+		lastMod, lastSize = info.ModTime(), info.Size()
+	}
+	ticker := time.NewTicker(WatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if info.ModTime().Equal(lastMod) && info.Size() == lastSize {
+				continue
+			}
+			lastMod, lastSize = info.ModTime(), info.Size()
+			onChange()
+		}
+	}
+}
+
+// Watch reloads the configuration whenever ConfigPath changes on disk
+func Watch(ctx context.Context) {
+	WatchFile(ctx, ConfigPath, func() {
+		log.Infof("[config] %s changed, reloading", ConfigPath)
+		if err := Reload(); err != nil {
+			log.Errorf("[config] Keeping previous configuration, reload failed: %v", err)
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/callebtc/electronwall/config"
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+// maxRejectMessageLength is the longest error that LND accepts from a channel
+// acceptor
+const maxRejectMessageLength = 500
+
+// channelFlagAnnounce is the bit of the channel flags that makes a channel
+// public
+const channelFlagAnnounce = 1
+
+// checkChannelConstraints returns a reason for every channel constraint that
+// a channel open request violates
+func checkChannelConstraints(conf *config.Config, req *lnrpc.ChannelAcceptRequest) []string {
+	cc := conf.ChannelConstraints(hex.EncodeToString(req.NodePubkey))
+	var reasons []string
+	if cc.MinFundingSat != nil && req.FundingAmt < *cc.MinFundingSat {
+		reasons = append(reasons, fmt.Sprintf("funding %d sat below %d sat", req.FundingAmt, *cc.MinFundingSat))
+	}
+	if cc.MaxFundingSat != nil && req.FundingAmt > *cc.MaxFundingSat {
+		reasons = append(reasons, fmt.Sprintf("funding %d sat above %d sat", req.FundingAmt, *cc.MaxFundingSat))
+	}
+	if cc.MaxPushMsat != nil && req.PushAmt > *cc.MaxPushMsat {
+		reasons = append(reasons, fmt.Sprintf("push %d msat above %d msat", req.PushAmt, *cc.MaxPushMsat))
+	}
+	public := req.ChannelFlags&channelFlagAnnounce != 0
+	switch {
+	case cc.ChannelFlags == config.ChannelFlagsPublic && !public:
+		reasons = append(reasons, "private channels not accepted")
+	case cc.ChannelFlags == config.ChannelFlagsPrivate && public:
+		reasons = append(reasons, "public channels not accepted")
+	}
+	if cc.MinCsvDelay != nil && req.CsvDelay < *cc.MinCsvDelay {
+		reasons = append(reasons, fmt.Sprintf("csv delay %d below %d blocks", req.CsvDelay, *cc.MinCsvDelay))
+	}
+	if cc.MaxCsvDelay != nil && req.CsvDelay > *cc.MaxCsvDelay {
+		reasons = append(reasons, fmt.Sprintf("csv delay %d above %d blocks", req.CsvDelay, *cc.MaxCsvDelay))
+	}
+	if cc.MaxChannelReserveSat != nil && req.ChannelReserve > *cc.MaxChannelReserveSat {
+		reasons = append(reasons, fmt.Sprintf("channel reserve %d sat above %d sat", req.ChannelReserve, *cc.MaxChannelReserveSat))
+	}
+	if !cc.AcceptsCommitmentType(req.CommitmentType) {
+		reasons = append(reasons, fmt.Sprintf("commitment type %s not accepted", req.CommitmentType))
+	}
+	if req.WantsZeroConf && cc.ZeroConf != config.ZeroConfGrant {
+		reasons = append(reasons, "zero-conf channels not accepted")
+	}
+	return reasons
+}
+
+// grantsZeroConf returns whether a zero-conf request is granted
+func grantsZeroConf(conf *config.Config, req *lnrpc.ChannelAcceptRequest) bool {
+	return req.WantsZeroConf && conf.ChannelConstraints(hex.EncodeToString(req.NodePubkey)).ZeroConf == config.ZeroConfGrant
+}
+
+// rejectMessage joins the reasons into an error for the channel acceptor
+// response that fits into the length limit of LND
+func rejectMessage(reasons []string) string {
+	message := strings.Join(reasons, "; ")
+	if len(message) > maxRejectMessageLength {
+		message = message[:maxRejectMessageLength-3] + "..."
+	}
+	return message
+}
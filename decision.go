@@ -0,0 +1,84 @@
+package main
+
+import (
+	"github.com/callebtc/electronwall/config"
+	"github.com/callebtc/electronwall/rules"
+	"github.com/callebtc/electronwall/types"
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+// combineDecisions combines the list decision and the rules decision
+// according to the combination policy. A script that returned no decision
+// neither accepts nor rejects.
+func combineDecisions(policy string, list bool, result rules.Result) bool {
+	switch policy {
+	case config.PolicyAny:
+		return list || result == rules.Allow
+	case config.PolicyListOnly:
+		return list
+	case config.PolicyRulesOnly:
+		return result != rules.Deny
+	case config.PolicyRulesOverride:
+		if result == rules.NoDecision {
+			return list
+		}
+		return result == rules.Allow
+	default:
+		return list && result != rules.Deny
+	}
+}
+
+// listResultString formats a list decision like a rules.Result
+func listResultString(accept bool) string {
+	if accept {
+		return "allow"
+	}
+	return "deny"
+}
+
+// listRejects returns whether a rejected decision was rejected by the list
+func listRejects(policy string, list bool, result rules.Result) bool {
+	listDecides := policy != config.PolicyRulesOnly &&
+		!(policy == config.PolicyRulesOverride && result != rules.NoDecision)
+	return !list && listDecides
+}
+
+// denyReason names the part of a rejected decision that rejected it
+func denyReason(policy string, list bool, listReason string, result rules.Result) string {
+	if listRejects(policy, list, result) {
+		return "list " + listReason
+	}
+	return "rules deny"
+}
+
+// forwardFailureCode returns the failure code for a rejected HTLC. It is the
+// code chosen by the list rule or the script that rejected it, or the default.
+func forwardFailureCode(conf *config.Config, list bool, listCode lnrpc.Failure_FailureCode, result rules.Result, rulesCode lnrpc.Failure_FailureCode) lnrpc.Failure_FailureCode {
+	code := rulesCode
+	if listRejects(conf.ForwardPolicy, list, result) {
+		code = listCode
+	}
+	if code == 0 {
+		return conf.ForwardFailure
+	}
+	return code
+}
+
+// checkForwardPolicies checks a forward against the fee, the CLTV and the flow
+// policy and its custom records, and returns the reason and the failure code
+// if it violates one of them
+func checkForwardPolicies(conf *config.Config, fwd types.HtlcForwardEvent) (string, lnrpc.Failure_FailureCode, bool) {
+	if reason, ok := checkFee(conf, fwd.Event, fwd.FeeMsat, fwd.FeePpm); !ok {
+		return reason, conf.FeeFailure, false
+	}
+	if reason, ok := checkCltv(conf, fwd); !ok {
+		return reason, conf.CltvFailure, false
+	}
+	if reason, ok := checkFlow(conf, fwd); !ok {
+		return reason, conf.FlowFailure, false
+	}
+	if reason, ok := checkCustomRecords(conf, fwd.Event.CustomRecords); !ok {
+		return reason, conf.CustomRecordsFailure, false
+	}
+	return "", 0, true
+}
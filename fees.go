@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/callebtc/electronwall/config"
+	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
+)
+
+// forwardFee returns the fee that a forward pays in msat and its fee rate in
+// ppm of the outgoing amount. The fee is negative if the HTLC pays less than
+// it asks us to forward.
+func forwardFee(event *routerrpc.ForwardHtlcInterceptRequest) (int64, int64) {
+	fee := int64(event.IncomingAmountMsat) - int64(event.OutgoingAmountMsat)
+	if event.OutgoingAmountMsat == 0 {
+		return fee, 0
+	}
+	return fee, fee * 1_000_000 / int64(event.OutgoingAmountMsat)
+}
+
+// checkFee returns whether a forward pays the minimum fee and fee rate of the
+// fee policy and which minimum it misses if it does not. A zero minimum is not
+// enforced.
+func checkFee(conf *config.Config, event *routerrpc.ForwardHtlcInterceptRequest, feeMsat, feePpm int64) (string, bool) {
+	minMsat, minPpm := conf.MinFees(event.IncomingCircuitKey.ChanId, event.OutgoingRequestedChanId)
+	if minMsat > 0 && feeMsat < int64(minMsat) {
+		return fmt.Sprintf("fee policy: fee %d msat below %d msat", feeMsat, minMsat), false
+	}
+	if minPpm > 0 && feePpm < int64(minPpm) {
+		return fmt.Sprintf("fee policy: fee rate %d ppm below %d ppm", feePpm, minPpm), false
+	}
+	return "", true
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/callebtc/electronwall/config"
+	"github.com/callebtc/electronwall/types"
+)
+
+// forwardFlow returns the flow policy that applies to a forward: the
+// same-peer action if the HTLC comes in and goes out over channels with the
+// same peer, otherwise the first matching action between channel groups
+func forwardFlow(conf *config.Config, fwd types.HtlcForwardEvent) (string, config.FlowAction, bool) {
+	if fwd.PubkeyFrom != "" && fwd.PubkeyFrom == fwd.PubkeyTo {
+		return "same-peer", conf.SamePeerFlow, true
+	}
+	in, out := fwd.Event.IncomingCircuitKey.ChanId, fwd.Event.OutgoingRequestedChanId
+	for _, g := range conf.GroupFlows {
+		if g.Matches(in, out) {
+			return "group " + g.String(), g.Action, true
+		}
+	}
+	return "", config.FlowAction{}, false
+}
+
+// checkFlow returns whether the flow policy allows a forward and which policy
+// denies it if it does not
+func checkFlow(conf *config.Config, fwd types.HtlcForwardEvent) (string, bool) {
+	flow, action, ok := forwardFlow(conf, fwd)
+	if !ok || action.Allows(fwd.FeePpm) {
+		return "", true
+	}
+	if action.Action == config.FlowMinFee {
+		return fmt.Sprintf("flow policy: %s forward with fee rate %d ppm below %d ppm", flow, fwd.FeePpm, action.MinFeePpm), false
+	}
+	return fmt.Sprintf("flow policy: %s forward denied", flow), false
+}
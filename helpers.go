@@ -67,3 +67,11 @@ func ParseChannelID(e uint64) string {
 	int_block1, _ := strconv.ParseInt(hexstr[12:], 16, 64)
 	return fmt.Sprintf("%dx%dx%d", int_block3, int_block2, int_block1)
 }
+
+// envOr returns the environment variable key or fallback if it is unset
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -4,13 +4,16 @@ import (
 	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/callebtc/electronwall/config"
 	"github.com/callebtc/electronwall/rules"
 	"github.com/callebtc/electronwall/types"
+	"github.com/lightningnetwork/lnd/lnrpc"
 	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
 	log "github.com/sirupsen/logrus"
 )
@@ -21,6 +24,7 @@ func (app *App) getHtlcForwardEvent(ctx context.Context, event *routerrpc.Forwar
 	channelEdge, err := app.lnd.getPubKeyFromChannel(ctx, event.IncomingCircuitKey.ChanId)
 	if err != nil {
 		log.Errorf("[forward] Error getting pubkey for channel %s", ParseChannelID(event.IncomingCircuitKey.ChanId))
+		return types.HtlcForwardEvent{}, err
 	}
 	var pubkeyFrom, aliasFrom, pubkeyTo, aliasTo string
 	if channelEdge.Node1Pub != app.myInfo.IdentityPubkey {
@@ -83,144 +87,421 @@ func (app *App) DispatchHTLCAcceptor(ctx context.Context) {
 	log.Info("[forward] Listening for incoming HTLCs")
 }
 
-// interceptHtlcEvents intercepts incoming htlc events
+// interceptHtlcEvents intercepts incoming htlc events. Intercepted HTLCs are
+// queued for a fixed number of workers and all responses are sent by a single
+// writer, since the interceptor stream does not allow concurrent sends. If the
+// queue is full, the HTLC is resolved with the overload action right away.
 func (app *App) interceptHtlcEvents(ctx context.Context) error {
 	// interceptor, decide whether to accept or reject
 	interceptor, err := app.lnd.htlcInterceptor(ctx)
 	if err != nil {
 		return err
 	}
+
+	conf := config.Current()
+	requests := make(chan *routerrpc.ForwardHtlcInterceptRequest, conf.ForwardQueueSize)
+	responses := make(chan *routerrpc.ForwardHtlcInterceptResponse, conf.ForwardWorkers)
+
+	// the single writer
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		for response := range responses {
+			err := interceptor.Send(response)
+			if err != nil {
+				log.Errorf("[forward] Error sending HTLC resolution: %v", err)
+			}
+		}
+	}()
+
+	// the workers
+	var workers sync.WaitGroup
+	for i := 0; i < conf.ForwardWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for event := range requests {
+				responses <- app.resolveHtlc(ctx, event)
+			}
+		}()
+	}
+
 	for {
 		event, err := interceptor.Recv()
 		if err != nil {
+			close(requests)
+			workers.Wait()
+			close(responses)
+			<-writerDone
 			return err
 		}
-		go func() {
+		select {
+		case requests <- event:
+		default:
+			responses <- app.overloadHtlcResponse(config.Current(), event)
+		}
+	}
+}
 
-			log.Tracef("[forward] HTLC event (%d->%d)", event.IncomingCircuitKey.ChanId, event.OutgoingRequestedChanId)
-			htlcForwardEvent, err := app.getHtlcForwardEvent(ctx, event)
-			if err != nil {
-				return
-			}
+// errDecisionTooLate is returned by a decision that lost the HTLC to the
+// fallback
+var errDecisionTooLate = errors.New("decision too late")
 
-			forward_info_string := fmt.Sprintf(
-				"from %s to %s (%d sat, chan_id:%s->%s, htlc_id:%d)",
-				htlcForwardEvent.AliasFrom,
-				htlcForwardEvent.AliasTo,
-				event.IncomingAmountMsat/1000,
-				ParseChannelID(event.IncomingCircuitKey.ChanId),
-				ParseChannelID(event.OutgoingRequestedChanId),
-				event.IncomingCircuitKey.HtlcId,
-			)
-
-			contextLogger := log.WithFields(log.Fields{
-				"event":       "forward_request",
-				"in_alias":    htlcForwardEvent.AliasFrom,
-				"out_alias":   htlcForwardEvent.AliasTo,
-				"amount":      event.IncomingAmountMsat / 1000,
-				"in_chan_id":  ParseChannelID(event.IncomingCircuitKey.ChanId),
-				"out_chan_id": ParseChannelID(event.OutgoingRequestedChanId),
-				"htlc_id":     event.IncomingCircuitKey.HtlcId,
-			})
+// resolveHtlc returns exactly one response for every intercepted HTLC. If the
+// decision fails, panics or does not finish before the evaluation deadline,
+// the configured fallback action is used.
+func (app *App) resolveHtlc(ctx context.Context, event *routerrpc.ForwardHtlcInterceptRequest) *routerrpc.ForwardHtlcInterceptResponse {
+	// use the same configuration for the whole decision
+	conf := config.Current()
 
-			// decision for routing
-			decision_chan := make(chan bool, 1)
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(conf.ForwardEvaluationTimeout)*time.Second)
+	defer cancel()
 
-			list_decision, err := app.htlcInterceptDecision(ctx, event, decision_chan)
-			if err != nil {
-				return
-			}
-			rules_decision, err := rules.Apply(htlcForwardEvent, decision_chan)
-			if err != nil {
-				fmt.Printf("script error: %v", err)
-				return
+	type result struct {
+		response *routerrpc.ForwardHtlcInterceptResponse
+		err      error
+	}
+	results := make(chan result, 1)
+	// the decision and the fallback claim the HTLC before they reserve
+	// anything for it, and only the first one resolves it
+	var claimed atomic.Bool
+	claim := func() bool {
+		return claimed.CompareAndSwap(false, true)
+	}
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				results <- result{err: fmt.Errorf("panic: %v", r)}
 			}
+		}()
+		response, err := app.htlcDecision(ctx, conf, event, claim)
+		results <- result{response, err}
+	}()
 
-			accept := true
-			if !list_decision || !rules_decision {
-				accept = false
+	var err error
+	select {
+	case r := <-results:
+		if r.err == nil {
+			return r.response
+		}
+		err = r.err
+	case <-ctx.Done():
+		err = fmt.Errorf("no decision within %ds", conf.ForwardEvaluationTimeout)
+		if !claim() {
+			// the decision was made in time and is about to be sent
+			r := <-results
+			if r.err == nil {
+				return r.response
 			}
+			err = r.err
+		}
+	}
+	return app.fallbackHtlcResponse(conf, event, err)
+}
 
-			response := &routerrpc.ForwardHtlcInterceptResponse{
-				IncomingCircuitKey: event.IncomingCircuitKey,
-			}
-			switch accept {
-			case true:
-				if config.Configuration.LogJson {
-					contextLogger.Infof("allow")
-				} else {
-					log.Infof("[forward] ✅ Allow HTLC %s", forward_info_string)
-				}
-				response.Action = routerrpc.ResolveHoldForwardAction_RESUME
-			case false:
-				if config.Configuration.LogJson {
-					contextLogger.Infof("deny")
-				} else {
-					log.Infof("[forward] ❌ Deny HTLC %s", forward_info_string)
-				}
-				response.Action = routerrpc.ResolveHoldForwardAction_FAIL
-			}
-			err = interceptor.Send(response)
-			if err != nil {
-				return
-			}
-		}()
+// fallbackHtlcResponse resolves an HTLC with the fallback action after an
+// internal error
+func (app *App) fallbackHtlcResponse(conf *config.Config, event *routerrpc.ForwardHtlcInterceptRequest, err error) *routerrpc.ForwardHtlcInterceptResponse {
+	total := app.fallbacks.Add(1)
+	return app.substituteHtlcResponse(conf, event, "fallback", conf.ForwardFallbackAction, err, total)
+}
+
+// overloadHtlcResponse resolves an HTLC with the overload action because the
+// queue is full
+func (app *App) overloadHtlcResponse(conf *config.Config, event *routerrpc.ForwardHtlcInterceptRequest) *routerrpc.ForwardHtlcInterceptResponse {
+	total := app.overloads.Add(1)
+	err := fmt.Errorf("queue of %d HTLCs is full", conf.ForwardQueueSize)
+	return app.substituteHtlcResponse(conf, event, "overload", conf.ForwardOverloadAction, err, total)
+}
+
+// substituteHtlcResponse resolves an HTLC that was not evaluated with action
+// and logs why. A resumed HTLC is tracked without its peer. Monitor mode
+// resumes the HTLC and only records the action it would have taken.
+func (app *App) substituteHtlcResponse(conf *config.Config, event *routerrpc.ForwardHtlcInterceptRequest, kind, action string, err error, total uint64) *routerrpc.ForwardHtlcInterceptResponse {
+	applied, logged := action, action
+	if conf.ForwardMonitor {
+		if action != "resume" {
+			app.monitor.recordForward(kind)
+		}
+		applied, logged = "resume", "would "+action
 	}
+	response := &routerrpc.ForwardHtlcInterceptResponse{
+		IncomingCircuitKey: event.IncomingCircuitKey,
+		Action:             routerrpc.ResolveHoldForwardAction_FAIL,
+	}
+	key := circuitKey{chanID: event.IncomingCircuitKey.ChanId, htlcID: event.IncomingCircuitKey.HtlcId}
+	fee, _ := forwardFee(event)
+	lifecycle := types.ForwardLifecycle{
+		IncomingChanId:     event.IncomingCircuitKey.ChanId,
+		IncomingHtlcId:     event.IncomingCircuitKey.HtlcId,
+		OutgoingChanId:     event.OutgoingRequestedChanId,
+		IncomingAmountMsat: event.IncomingAmountMsat,
+		OutgoingAmountMsat: event.OutgoingAmountMsat,
+		FeeMsat:            fee,
+		Decision:           kind,
+		Reason:             err.Error(),
+		Intercepted:        time.Now(),
+	}
+	if applied == "resume" {
+		response.Action = routerrpc.ResolveHoldForwardAction_RESUME
+		app.inflight.add(key, inflightHtlc{outChan: event.OutgoingRequestedChanId, amtMsat: event.OutgoingAmountMsat, since: time.Now(), expiry: event.IncomingExpiry})
+	} else {
+		response.FailureCode = conf.ForwardFailure
+		lifecycle.FailureCode = conf.ForwardFailure.String()
+	}
+	app.lifecycles.intercepted(key, lifecycle, event.IncomingExpiry, applied == "resume")
+	if conf.LogJson {
+		log.WithFields(log.Fields{
+			"event":       "forward_" + kind,
+			"action":      logged,
+			"error":       err.Error(),
+			"in_chan_id":  ParseChannelID(event.IncomingCircuitKey.ChanId),
+			"out_chan_id": ParseChannelID(event.OutgoingRequestedChanId),
+			"htlc_id":     event.IncomingCircuitKey.HtlcId,
+			"total":       total,
+		}).Warnf(kind)
+	} else {
+		log.Warnf("[forward] ⚠️ %s: %s HTLC (chan_id:%s->%s, htlc_id:%d): %v (%d so far)",
+			kind, logged,
+			ParseChannelID(event.IncomingCircuitKey.ChanId),
+			ParseChannelID(event.OutgoingRequestedChanId),
+			event.IncomingCircuitKey.HtlcId,
+			err, total)
+	}
+	return response
+}
+
+// htlcDecision enriches the intercepted HTLC, evaluates the lists and rules
+// and returns the response for LND
+func (app *App) htlcDecision(ctx context.Context, conf *config.Config, event *routerrpc.ForwardHtlcInterceptRequest, claim func() bool) (*routerrpc.ForwardHtlcInterceptResponse, error) {
+	log.Tracef("[forward] HTLC event (%d->%d)", event.IncomingCircuitKey.ChanId, event.OutgoingRequestedChanId)
+	intercepted := time.Now()
+	htlcForwardEvent, err := app.getHtlcForwardEvent(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+	in, out := event.IncomingCircuitKey.ChanId, event.OutgoingRequestedChanId
+	htlcForwardEvent.RateLimits = app.limiter.levels(conf, in, out, htlcForwardEvent.PubkeyFrom)
+	htlcForwardEvent.Reputation = app.reputation.get(conf, htlcForwardEvent.PubkeyFrom)
+	htlcForwardEvent.FeeMsat, htlcForwardEvent.FeePpm = forwardFee(event)
+	app.setCltv(&htlcForwardEvent)
+	htlcForwardEvent.IncomingChannel = app.channels.get(in)
+	htlcForwardEvent.OutgoingChannel = app.channels.get(out)
+	htlcForwardEvent.CustomRecords = decodeCustomRecords(event.CustomRecords)
+
+	forward_info_string := fmt.Sprintf(
+		"from %s to %s (%d sat, fee %d msat, %d ppm, chan_id:%s->%s, htlc_id:%d)",
+		htlcForwardEvent.AliasFrom,
+		htlcForwardEvent.AliasTo,
+		event.IncomingAmountMsat/1000,
+		htlcForwardEvent.FeeMsat,
+		htlcForwardEvent.FeePpm,
+		ParseChannelID(event.IncomingCircuitKey.ChanId),
+		ParseChannelID(event.OutgoingRequestedChanId),
+		event.IncomingCircuitKey.HtlcId,
+	)
+
+	contextLogger := log.WithFields(log.Fields{
+		"event":       "forward_request",
+		"in_alias":    htlcForwardEvent.AliasFrom,
+		"out_alias":   htlcForwardEvent.AliasTo,
+		"amount":      event.IncomingAmountMsat / 1000,
+		"fee_msat":    htlcForwardEvent.FeeMsat,
+		"fee_ppm":     htlcForwardEvent.FeePpm,
+		"cltv_delta":  htlcForwardEvent.CltvDelta,
+		"in_chan_id":  ParseChannelID(event.IncomingCircuitKey.ChanId),
+		"out_chan_id": ParseChannelID(event.OutgoingRequestedChanId),
+		"htlc_id":     event.IncomingCircuitKey.HtlcId,
+	})
+
+	// decision for routing
+	decision_chan := make(chan bool, 1)
+
+	list_decision, list_reason, list_failure, err := app.htlcInterceptDecision(ctx, conf, htlcForwardEvent, decision_chan)
+	if err != nil {
+		return nil, err
+	}
+	rules_decision := rules.NoDecision
+	var rules_failure lnrpc.Failure_FailureCode
+	if conf.ForwardPolicy != config.PolicyListOnly {
+		rules_decision, rules_failure, err = rules.EvaluateForward(ctx, conf, htlcForwardEvent)
+		if err != nil {
+			return nil, fmt.Errorf("script error: %w", err)
+		}
+	}
+
+	accept := combineDecisions(conf.ForwardPolicy, list_decision, rules_decision)
+
+	decision_info_string := fmt.Sprintf("[policy %s: list %s (%s), rules %s]", conf.ForwardPolicy, listResultString(list_decision), list_reason, rules_decision)
+	if flow, action, ok := forwardFlow(conf, htlcForwardEvent); ok {
+		decision_info_string += fmt.Sprintf(" [%s: %s]", flow, action)
+		contextLogger = contextLogger.WithField("flow", fmt.Sprintf("%s: %s", flow, action))
+	}
+	contextLogger = contextLogger.WithFields(log.Fields{
+		"policy":         conf.ForwardPolicy,
+		"list_decision":  listResultString(list_decision),
+		"list_reason":    list_reason,
+		"rules_decision": rules_decision.String(),
+	})
+
+	// the fallback resolves the HTLC once the deadline has passed, so a
+	// late decision must not reserve resources or record a lifecycle
+	if !claim() {
+		return nil, errDecisionTooLate
+	}
+
+	// an accepted HTLC still has to meet the fee and CLTV policies and pass
+	// the resource limits
+	reason := ""
+	var failure lnrpc.Failure_FailureCode
+	key := circuitKey{chanID: in, htlcID: event.IncomingCircuitKey.HtlcId}
+	htlc := inflightHtlc{peer: htlcForwardEvent.PubkeyFrom, outChan: out, amtMsat: event.OutgoingAmountMsat, since: time.Now(), expiry: event.IncomingExpiry}
+	if accept {
+		var ok bool
+		reason, failure, ok = checkForwardPolicies(conf, htlcForwardEvent)
+		if ok {
+			reason, failure, ok = app.reserveForward(conf, key, htlc, htlcForwardEvent.Reputation)
+		}
+		if !ok {
+			accept = false
+			decision_info_string += " [" + reason + "]"
+			contextLogger = contextLogger.WithField("limit", reason)
+		}
+	}
+	if !accept && reason == "" {
+		reason = denyReason(conf.ForwardPolicy, list_decision, list_reason, rules_decision)
+		failure = forwardFailureCode(conf, list_decision, list_failure, rules_decision, rules_failure)
+	}
+
+	response := &routerrpc.ForwardHtlcInterceptResponse{
+		IncomingCircuitKey: event.IncomingCircuitKey,
+	}
+	lifecycle := types.ForwardLifecycle{
+		IncomingChanId:     in,
+		IncomingHtlcId:     event.IncomingCircuitKey.HtlcId,
+		OutgoingChanId:     out,
+		PubkeyFrom:         htlcForwardEvent.PubkeyFrom,
+		AliasFrom:          htlcForwardEvent.AliasFrom,
+		PubkeyTo:           htlcForwardEvent.PubkeyTo,
+		AliasTo:            htlcForwardEvent.AliasTo,
+		IncomingAmountMsat: event.IncomingAmountMsat,
+		OutgoingAmountMsat: event.OutgoingAmountMsat,
+		FeeMsat:            htlcForwardEvent.FeeMsat,
+		Reason:             reason,
+		Intercepted:        intercepted,
+	}
+	switch {
+	case accept:
+		if conf.LogJson {
+			contextLogger.Infof("allow")
+		} else {
+			log.Infof("[forward] ✅ Allow HTLC %s %s", forward_info_string, decision_info_string)
+		}
+		response.Action = routerrpc.ResolveHoldForwardAction_RESUME
+		lifecycle.Decision = "allow"
+	case conf.ForwardMonitor:
+		if conf.LogJson {
+			contextLogger.WithField("reason", reason).Infof("would deny")
+		} else {
+			log.Infof("[forward] 👀 Would deny HTLC %s %s", forward_info_string, decision_info_string)
+		}
+		app.monitor.recordForward(reason)
+		app.inflight.add(key, htlc)
+		response.Action = routerrpc.ResolveHoldForwardAction_RESUME
+		lifecycle.Decision = "monitor"
+	default:
+		if conf.LogJson {
+			contextLogger.WithFields(log.Fields{
+				"reason":       reason,
+				"failure_code": failure.String(),
+			}).Infof("deny")
+		} else {
+			log.Infof("[forward] ❌ Deny HTLC %s %s with %s", forward_info_string, decision_info_string, failure)
+		}
+		response.Action = routerrpc.ResolveHoldForwardAction_FAIL
+		response.FailureCode = failure
+		lifecycle.Decision = "deny"
+		lifecycle.FailureCode = failure.String()
+	}
+	app.lifecycles.intercepted(key, lifecycle, event.IncomingExpiry, response.Action == routerrpc.ResolveHoldForwardAction_RESUME)
+	return response, nil
+}
+
+// reserveForward checks an accepted HTLC against the reputation of its peer,
+// the in-flight caps, the liquidity floor and the rate limits. If it passes all of them, it is
+// tracked as in flight. Otherwise, the limit that it exceeds is returned with
+// the failure code of the limit.
+func (app *App) reserveForward(conf *config.Config, key circuitKey, htlc inflightHtlc, rep types.Reputation) (string, lnrpc.Failure_FailureCode, bool) {
+	// peers with a low reputation only get plentiful resources
+	lowScore := rep.Score < conf.ForwardReputation.MinScore
+	if lowScore {
+		_, channel := app.inflight.totals(htlc.peer, htlc.outChan)
+		maxHtlcs := conf.ReputationMaxChannelHtlcs.Of(app.channels.maxHtlcs(htlc.outChan, true))
+		busy := maxHtlcs > 0 && channel.htlcs >= maxHtlcs ||
+			conf.ForwardReputation.MaxChannelMsat > 0 && channel.msat+htlc.amtMsat > conf.ForwardReputation.MaxChannelMsat
+		if busy {
+			reason := fmt.Sprintf("reputation: score %.2f below %.2f with %d HTLCs (%d msat) in flight on channel",
+				rep.Score, conf.ForwardReputation.MinScore, channel.htlcs, channel.msat)
+			return reason, conf.ReputationFailure, false
+		}
+	}
+	if reason, ok := app.inflight.reserve(app.inflightCaps(conf.InflightLimits, key.chanID, htlc.outChan), key, htlc); !ok {
+		return reason, conf.InflightFailure, false
+	}
+	// the liquidity is checked after the reservation so that concurrent
+	// HTLCs on the same channel see each other
+	if lowScore {
+		floor := conf.ReputationMinLocalBalance
+		if balance, ok := app.keepsBalance(key, htlc, floor); !ok {
+			app.inflight.resolve(key)
+			reason := fmt.Sprintf("reputation: score %.2f below %.2f with local balance %d sat below %s",
+				rep.Score, conf.ForwardReputation.MinScore, balance/1000, floor)
+			return reason, conf.ReputationFailure, false
+		}
+	}
+	if reason, ok := app.checkLiquidity(conf, key, htlc); !ok {
+		app.inflight.resolve(key)
+		return reason, conf.LiquidityFailure, false
+	}
+	if reason, ok := app.limiter.take(conf, key.chanID, htlc.outChan, htlc.peer, htlc.amtMsat); !ok {
+		app.inflight.resolve(key)
+		return reason, conf.RateLimitFailure, false
+	}
+	return "", 0, true
 }
 
 // htlcInterceptDecision implements the rules upon which the
 // decision is made whether or not to relay an HTLC to the next
 // peer.
 // The decision is made based on the following rules:
-// 1. Either use a allowlist or a denylist.
-// 2. If a single channel ID is used (12320768x65536x0), check the incoming ID of the HTLC against the list.
-// 3. If two channel IDs are used (7929856x65537x0->7143424x65537x0), check the incoming ID and the outgoing ID of the HTLC against the list.
-func (app *App) htlcInterceptDecision(ctx context.Context, event *routerrpc.ForwardHtlcInterceptRequest, decision_chan chan bool) (bool, error) {
-	var accept bool
-	var listToParse []string
-
-	// // sleep for 60 seconds
-	// time.Sleep(60 * time.Second)
-
-	// determine filtering mode and list to parse
-	switch config.Configuration.ForwardMode {
-	case "allowlist":
-		accept = false
-		listToParse = config.Configuration.ForwardAllowlist
-	case "denylist":
-		accept = true
-		listToParse = config.Configuration.ForwardDenylist
-	default:
-		return false, fmt.Errorf("unknown forward mode: %s", config.Configuration.ForwardMode)
+// 1. The rules of the forward chain are checked in order. An allowlist or a denylist is a chain with a single action.
+// 2. If a single channel ID is used (12320768x65536x0), check the incoming ID of the HTLC against the rule.
+// 3. If two channel IDs are used (7929856x65537x0->7143424x65537x0), check the incoming ID and the outgoing ID of the HTLC against the rule.
+// 4. Peer pubkeys can be used instead of channel IDs in the same way (02ab... or 02ab...->03cd...), they are checked against the incoming and the outgoing peer.
+// 5. The first matching rule decides. If no rule matches, the default of the chain applies.
+// The returned reason names the rule that decided, along with the failure
+// code of the rule if it set one.
+func (app *App) htlcInterceptDecision(ctx context.Context, conf *config.Config, htlcForwardEvent types.HtlcForwardEvent, decision_chan chan bool) (bool, string, lnrpc.Failure_FailureCode, error) {
+	if conf.ForwardMode == "passthrough" {
+		// only reachable if passthrough was enabled by a config reload
+		return true, "passthrough", 0, nil
 	}
 
-	// parse list and decide
-	for _, forward_list_entry := range listToParse {
-		if forward_list_entry == "*" {
-			accept = !accept
+	accept := conf.ForwardDefaultAccept
+	reason := "default " + listResultString(accept)
+	var failure lnrpc.Failure_FailureCode
+	event := htlcForwardEvent.Event
+	for i, rule := range conf.ForwardRules {
+		if rule.Matches(event.IncomingCircuitKey.ChanId, event.OutgoingRequestedChanId, htlcForwardEvent.PubkeyFrom, htlcForwardEvent.PubkeyTo) {
+			accept = rule.Accept
+			reason = fmt.Sprintf("rule %d: %s", i+1, rule)
+			failure = rule.FailureCode
 			break
 		}
-		if len(strings.Split(forward_list_entry, "->")) == 2 {
-			// check if entry is a pair of from->to
-			split := strings.Split(forward_list_entry, "->")
-			from_channel_id, to_channel_id := split[0], split[1]
-			if (ParseChannelID(event.IncomingCircuitKey.ChanId) == from_channel_id || from_channel_id == "*") &&
-				(ParseChannelID(event.OutgoingRequestedChanId) == to_channel_id || to_channel_id == "*") {
-				accept = !accept
-				log.Tracef("[test] Incoming: %s <-> %s, Outgoing: %s <-> %s", ParseChannelID(event.IncomingCircuitKey.ChanId), from_channel_id, ParseChannelID(event.OutgoingRequestedChanId), to_channel_id)
-				break
-			}
-		} else {
-			// single entry
-			if ParseChannelID(event.IncomingCircuitKey.ChanId) == forward_list_entry {
-				accept = !accept
-				break
-			}
-		}
 	}
 	// decision_chan <- accept
-	log.Infof("[list] decision: %t", accept)
-	return accept, nil
+	log.Infof("[list] decision: %t (%s)", accept, reason)
+	return accept, reason, failure, nil
 }
 
 // logHtlcEvents reports on incoming htlc events
@@ -240,6 +521,8 @@ func (app *App) logHtlcEvents(ctx context.Context) error {
 		if event.EventType != routerrpc.HtlcEvent_FORWARD {
 			continue
 		}
+		// balances and pending HTLCs of the channels changed
+		app.channels.invalidate()
 
 		contextLogger := func(event *routerrpc.HtlcEvent) *log.Entry {
 			b, err := json.Marshal(event)
@@ -252,29 +535,55 @@ func (app *App) logHtlcEvents(ctx context.Context) error {
 			})
 		}
 
+		// settled and failed HTLCs are no longer in flight and count
+		// towards the reputation of their peer
+		held := ""
+		outcome, resolved := outcomeSettled, true
+		resolution, failure := resolutionSettle, ""
+		switch e := event.Event.(type) {
+		case *routerrpc.HtlcEvent_SettleEvent:
+		case *routerrpc.HtlcEvent_ForwardFailEvent:
+			outcome = outcomeFailed
+			resolution = resolutionForwardFail
+		case *routerrpc.HtlcEvent_LinkFailEvent:
+			outcome = outcomeLinkFailed
+			resolution = resolutionLinkFail
+			failure = e.LinkFailEvent.GetFailureString()
+		default:
+			resolved = false
+		}
+		if resolved {
+			if htlc, ok := app.inflight.resolve(circuitKey{chanID: event.IncomingChannelId, htlcID: event.IncomingHtlcId}); ok {
+				hold := time.Since(htlc.since)
+				held = fmt.Sprintf(", held %s", hold.Round(time.Millisecond))
+				app.reputation.record(config.Current(), htlc.peer, outcome, hold)
+			}
+		}
+
+		logJson := config.Current().LogJson
 		switch event.Event.(type) {
 		case *routerrpc.HtlcEvent_SettleEvent:
-			if config.Configuration.LogJson {
+			if logJson {
 				contextLogger(event).Infof("SettleEvent")
 				// contextLogger.Debugf("[forward] Preimage: %s", hex.EncodeToString(event.GetSettleEvent().Preimage))
 			} else {
-				log.Infof("[forward] ⚡️ HTLC SettleEvent (chan_id:%s, htlc_id:%d)", ParseChannelID(event.IncomingChannelId), event.IncomingHtlcId)
+				log.Infof("[forward] ⚡️ HTLC SettleEvent (chan_id:%s, htlc_id:%d%s)", ParseChannelID(event.IncomingChannelId), event.IncomingHtlcId, held)
 				if event.GetSettleEvent() != nil && event.GetSettleEvent().Preimage != nil {
 					log.Debugf("[forward] Preimage: %s", hex.EncodeToString(event.GetSettleEvent().Preimage))
 				}
 			}
 
 		case *routerrpc.HtlcEvent_ForwardFailEvent:
-			if config.Configuration.LogJson {
+			if logJson {
 				contextLogger(event).Infof("ForwardFailEvent")
 				// contextLogger.Debugf("[forward] Reason: %s", event.GetForwardFailEvent())
 			} else {
-				log.Infof("[forward] HTLC ForwardFailEvent (chan_id:%s, htlc_id:%d)", ParseChannelID(event.IncomingChannelId), event.IncomingHtlcId)
+				log.Infof("[forward] HTLC ForwardFailEvent (chan_id:%s, htlc_id:%d%s)", ParseChannelID(event.IncomingChannelId), event.IncomingHtlcId, held)
 				// log.Debugf("[forward] Reason: %s", event.GetForwardFailEvent().String())
 			}
 
 		case *routerrpc.HtlcEvent_ForwardEvent:
-			if config.Configuration.LogJson {
+			if logJson {
 				contextLogger(event).Infof("ForwardEvent")
 			} else {
 				log.Infof("[forward] HTLC ForwardEvent (chan_id:%s, htlc_id:%d)", ParseChannelID(event.IncomingChannelId), event.IncomingHtlcId)
@@ -283,15 +592,19 @@ func (app *App) logHtlcEvents(ctx context.Context) error {
 			// log.Debugf("[forward] Details: %s", event.GetForwardEvent().String())
 
 		case *routerrpc.HtlcEvent_LinkFailEvent:
-			if config.Configuration.LogJson {
+			if logJson {
 				contextLogger(event).Infof("LinkFailEvent")
 				// contextLogger(event).Debugf("[forward] Reason: %s", event.GetLinkFailEvent().FailureString)
 			} else {
-				log.Infof("[forward] HTLC LinkFailEvent (chan_id:%s, htlc_id:%d)", ParseChannelID(event.IncomingChannelId), event.IncomingHtlcId)
+				log.Infof("[forward] HTLC LinkFailEvent (chan_id:%s, htlc_id:%d%s)", ParseChannelID(event.IncomingChannelId), event.IncomingHtlcId, held)
 				log.Debugf("[forward] Reason: %s", event.GetLinkFailEvent().FailureString)
 			}
 
 		}
 
+		// the lifecycle is complete after the event was logged
+		if resolved {
+			app.lifecycles.resolve(circuitKey{chanID: event.IncomingChannelId, htlcID: event.IncomingHtlcId}, resolution, failure)
+		}
 	}
 }
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/callebtc/electronwall/config"
+)
+
+// circuitKey identifies an HTLC by its incoming channel and HTLC ID
+type circuitKey struct {
+	chanID uint64
+	htlcID uint64
+}
+
+// inflightHtlc is an HTLC that was resumed and is not settled or failed yet.
+// expiry is the block height at which the incoming HTLC expires.
+type inflightHtlc struct {
+	peer    string
+	outChan uint64
+	amtMsat uint64
+	since   time.Time
+	expiry  uint32
+}
+
+// inflightTotals are the HTLCs in flight from a peer or on a channel
+type inflightTotals struct {
+	htlcs int
+	msat  uint64
+}
+
+// inflightCaps are the in-flight caps of an HTLC with the slot caps resolved
+// for its channels
+type inflightCaps struct {
+	peerHtlcs    int
+	peerMsat     uint64
+	channelHtlcs int
+	channelMsat  uint64
+}
+
+// inflightCaps resolves the in-flight caps for an HTLC from channel in to
+// channel out. Peer slot percentages refer to the slots of the incoming
+// channel and channel slot percentages to those of the outgoing channel.
+func (app *App) inflightCaps(limits config.InflightLimits, in, out uint64) inflightCaps {
+	return inflightCaps{
+		peerHtlcs:    limits.PeerHtlcs.Of(app.channels.maxHtlcs(in, false)),
+		peerMsat:     limits.PeerMsat,
+		channelHtlcs: limits.ChannelHtlcs.Of(app.channels.maxHtlcs(out, true)),
+		channelMsat:  limits.ChannelMsat,
+	}
+}
+
+// inflightTracker links the HTLCs that electronwall resumed to their settle or
+// fail events and keeps the totals per incoming peer and outgoing channel
+type inflightTracker struct {
+	mu       sync.Mutex
+	htlcs    map[circuitKey]inflightHtlc
+	peers    map[string]*inflightTotals
+	channels map[uint64]*inflightTotals
+}
+
+func newInflightTracker() *inflightTracker {
+	return &inflightTracker{
+		htlcs:    map[circuitKey]inflightHtlc{},
+		peers:    map[string]*inflightTotals{},
+		channels: map[uint64]*inflightTotals{},
+	}
+}
+
+// reserve adds the HTLC if it fits into caps. Otherwise, it returns the cap
+// that would be exceeded.
+func (t *inflightTracker) reserve(caps inflightCaps, key circuitKey, htlc inflightHtlc) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	peer, channel := t.totalsLocked(htlc.peer, htlc.outChan)
+	switch {
+	case htlc.peer != "" && caps.peerHtlcs > 0 && peer.htlcs >= caps.peerHtlcs:
+		return fmt.Sprintf("in-flight cap: %d HTLCs from peer", caps.peerHtlcs), false
+	case htlc.peer != "" && caps.peerMsat > 0 && peer.msat+htlc.amtMsat > caps.peerMsat:
+		return fmt.Sprintf("in-flight cap: %d msat from peer", caps.peerMsat), false
+	case caps.channelHtlcs > 0 && channel.htlcs >= caps.channelHtlcs:
+		return fmt.Sprintf("in-flight cap: %d HTLCs on channel", caps.channelHtlcs), false
+	case caps.channelMsat > 0 && channel.msat+htlc.amtMsat > caps.channelMsat:
+		return fmt.Sprintf("in-flight cap: %d msat on channel", caps.channelMsat), false
+	}
+	t.addLocked(key, htlc)
+	return "", true
+}
+
+// add adds the HTLC regardless of the caps
+func (t *inflightTracker) add(key circuitKey, htlc inflightHtlc) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.addLocked(key, htlc)
+}
+
+func (t *inflightTracker) addLocked(key circuitKey, htlc inflightHtlc) {
+	if _, ok := t.htlcs[key]; ok {
+		return
+	}
+	t.htlcs[key] = htlc
+	if htlc.peer != "" {
+		if t.peers[htlc.peer] == nil {
+			t.peers[htlc.peer] = &inflightTotals{}
+		}
+		t.peers[htlc.peer].htlcs++
+		t.peers[htlc.peer].msat += htlc.amtMsat
+	}
+	if t.channels[htlc.outChan] == nil {
+		t.channels[htlc.outChan] = &inflightTotals{}
+	}
+	t.channels[htlc.outChan].htlcs++
+	t.channels[htlc.outChan].msat += htlc.amtMsat
+}
+
+// resolve removes a settled or failed HTLC and returns it
+func (t *inflightTracker) resolve(key circuitKey) (inflightHtlc, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.resolveLocked(key)
+}
+
+// expire removes the HTLCs that expired before the block height and returns
+// how many. They are resolved by then, so their settle or fail event was
+// missed, for example while the event subscription was down.
+func (t *inflightTracker) expire(height uint32) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	expired := 0
+	for key, htlc := range t.htlcs {
+		if htlc.expiry > 0 && htlc.expiry < height {
+			t.resolveLocked(key)
+			expired++
+		}
+	}
+	return expired
+}
+
+func (t *inflightTracker) resolveLocked(key circuitKey) (inflightHtlc, bool) {
+	htlc, ok := t.htlcs[key]
+	if !ok {
+		return inflightHtlc{}, false
+	}
+	delete(t.htlcs, key)
+	if peer := t.peers[htlc.peer]; peer != nil {
+		peer.htlcs--
+		peer.msat -= htlc.amtMsat
+		if peer.htlcs == 0 {
+			delete(t.peers, htlc.peer)
+		}
+	}
+	if channel := t.channels[htlc.outChan]; channel != nil {
+		channel.htlcs--
+		channel.msat -= htlc.amtMsat
+		if channel.htlcs == 0 {
+			delete(t.channels, htlc.outChan)
+		}
+	}
+	return htlc, true
+}
+
+// totals returns the HTLCs in flight from the peer and on the outgoing channel
+func (t *inflightTracker) totals(peer string, outChan uint64) (inflightTotals, inflightTotals) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.totalsLocked(peer, outChan)
+}
+
+func (t *inflightTracker) totalsLocked(peer string, outChan uint64) (inflightTotals, inflightTotals) {
+	var p, c inflightTotals
+	if totals := t.peers[peer]; totals != nil {
+		p = *totals
+	}
+	if totals := t.channels[outChan]; totals != nil {
+		c = *totals
+	}
+	return p, c
+}
+
+// sentSince returns the msat of the HTLCs other than key that were resumed on
+// the outgoing channel after t
+func (t *inflightTracker) sentSince(outChan uint64, since time.Time, key circuitKey) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var msat uint64
+	for k, htlc := range t.htlcs {
+		if k != key && htlc.outChan == outChan && htlc.since.After(since) {
+			msat += htlc.amtMsat
+		}
+	}
+	return msat
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/callebtc/electronwall/config"
+	"github.com/callebtc/electronwall/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// resolutions of a forward lifecycle
+const (
+	resolutionSettle      = "settle"
+	resolutionForwardFail = "forward_fail"
+	resolutionLinkFail    = "link_fail"
+	resolutionFail        = "fail"
+	// resolutionExpired completes the lifecycle of an HTLC whose settle or
+	// fail event was missed
+	resolutionExpired = "expired"
+)
+
+// lifecycleTracker links intercepted HTLCs to their settle or fail events.
+// Every completed lifecycle is logged and passed to the subscribers.
+type lifecycleTracker struct {
+	mu          sync.Mutex
+	pending     map[circuitKey]pendingLifecycle
+	subscribers []func(types.ForwardLifecycle)
+}
+
+// pendingLifecycle is the lifecycle of a resumed HTLC whose incoming HTLC
+// expires at the block height expiry
+type pendingLifecycle struct {
+	lifecycle types.ForwardLifecycle
+	expiry    uint32
+}
+
+func newLifecycleTracker() *lifecycleTracker {
+	return &lifecycleTracker{pending: map[circuitKey]pendingLifecycle{}}
+}
+
+// SubscribeLifecycles calls fn with every completed forward lifecycle, for
+// example to export metrics. fn must not block.
+func (app *App) SubscribeLifecycles(fn func(types.ForwardLifecycle)) {
+	app.lifecycles.subscribe(fn)
+}
+
+func (t *lifecycleTracker) subscribe(fn func(types.ForwardLifecycle)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.subscribers = append(t.subscribers, fn)
+}
+
+// intercepted records the decision on an HTLC that expires at the block
+// height expiry. The lifecycle of a resumed HTLC completes with its settle or
+// fail event, the lifecycle of a failed HTLC completes right away.
+func (t *lifecycleTracker) intercepted(key circuitKey, lifecycle types.ForwardLifecycle, expiry uint32, resumed bool) {
+	if !resumed {
+		lifecycle.Resolution = resolutionFail
+		lifecycle.Hold = time.Since(lifecycle.Intercepted)
+		t.complete(lifecycle, true)
+		return
+	}
+	t.mu.Lock()
+	t.pending[key] = pendingLifecycle{lifecycle: lifecycle, expiry: expiry}
+	t.mu.Unlock()
+}
+
+// resolve completes the lifecycle of a resumed HTLC with its outcome
+func (t *lifecycleTracker) resolve(key circuitKey, resolution, failure string) {
+	t.mu.Lock()
+	pending, ok := t.pending[key]
+	delete(t.pending, key)
+	t.mu.Unlock()
+	if !ok {
+		return
+	}
+	lifecycle := pending.lifecycle
+	lifecycle.Resolution = resolution
+	lifecycle.FailureString = failure
+	lifecycle.Hold = time.Since(lifecycle.Intercepted)
+	if resolution == resolutionSettle {
+		lifecycle.EarnedFeeMsat = lifecycle.FeeMsat
+	}
+	t.complete(lifecycle, true)
+}
+
+// expire completes the lifecycles of HTLCs that expired before the block
+// height without logging them and returns how many. Their settle or fail
+// event was missed.
+func (t *lifecycleTracker) expire(height uint32) int {
+	var expired []types.ForwardLifecycle
+	t.mu.Lock()
+	for key, pending := range t.pending {
+		if pending.expiry > 0 && pending.expiry < height {
+			delete(t.pending, key)
+			expired = append(expired, pending.lifecycle)
+		}
+	}
+	t.mu.Unlock()
+	for _, lifecycle := range expired {
+		lifecycle.Resolution = resolutionExpired
+		lifecycle.Hold = time.Since(lifecycle.Intercepted)
+		t.complete(lifecycle, false)
+	}
+	return len(expired)
+}
+
+// complete passes a completed lifecycle to the subscribers and logs it if
+// logged is set
+func (t *lifecycleTracker) complete(lifecycle types.ForwardLifecycle, logged bool) {
+	if logged {
+		logLifecycle(config.Current(), lifecycle)
+	}
+	t.mu.Lock()
+	subscribers := t.subscribers
+	t.mu.Unlock()
+	for _, fn := range subscribers {
+		fn(lifecycle)
+	}
+}
+
+// logLifecycle logs a completed lifecycle as a single entry
+func logLifecycle(conf *config.Config, l types.ForwardLifecycle) {
+	if conf.LogJson {
+		log.WithFields(log.Fields{
+			"event":          "forward_lifecycle",
+			"in_chan_id":     ParseChannelID(l.IncomingChanId),
+			"out_chan_id":    ParseChannelID(l.OutgoingChanId),
+			"htlc_id":        l.IncomingHtlcId,
+			"in_alias":       l.AliasFrom,
+			"out_alias":      l.AliasTo,
+			"amount":         l.IncomingAmountMsat / 1000,
+			"fee_msat":       l.FeeMsat,
+			"decision":       l.Decision,
+			"reason":         l.Reason,
+			"failure_code":   l.FailureCode,
+			"resolution":     l.Resolution,
+			"failure_string": l.FailureString,
+			"earned_msat":    l.EarnedFeeMsat,
+			"hold_ms":        l.Hold.Milliseconds(),
+		}).Infof("lifecycle")
+		return
+	}
+	outcome := l.Resolution
+	if l.FailureString != "" {
+		outcome += " (" + l.FailureString + ")"
+	}
+	log.Infof("[forward] HTLC lifecycle from %s to %s (chan_id:%s->%s, htlc_id:%d): %s, %s after %s, earned %d msat",
+		l.AliasFrom, l.AliasTo,
+		ParseChannelID(l.IncomingChanId),
+		ParseChannelID(l.OutgoingChanId),
+		l.IncomingHtlcId,
+		l.Decision, outcome, l.Hold.Round(time.Millisecond), l.EarnedFeeMsat)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/callebtc/electronwall/config"
+)
+
+// checkLiquidity returns whether the outgoing channel keeps the local balance
+// floor of the liquidity policy after the HTLC
+func (app *App) checkLiquidity(conf *config.Config, key circuitKey, htlc inflightHtlc) (string, bool) {
+	snapshot, _ := app.channels.lookup(htlc.outChan)
+	if snapshot == nil {
+		return "", true
+	}
+	floor := conf.LocalBalanceFloor(htlc.outChan, snapshot.RemotePubkey)
+	if balance, ok := app.keepsBalance(key, htlc, floor); !ok {
+		return fmt.Sprintf("liquidity floor: local balance %d sat below %s", balance/1000, floor), false
+	}
+	return "", true
+}
+
+// keepsBalance returns whether the outgoing channel of an HTLC keeps floor
+// after the HTLC, along with its local balance in msat. The balance of the
+// channel snapshot is reduced by the HTLCs that were resumed after the
+// snapshot was taken. A channel without a snapshot keeps any floor.
+func (app *App) keepsBalance(key circuitKey, htlc inflightHtlc, floor config.Floor) (int64, bool) {
+	snapshot, updated := app.channels.lookup(htlc.outChan)
+	if snapshot == nil || floor == (config.Floor{}) {
+		return 0, true
+	}
+	sent := app.inflight.sentSince(htlc.outChan, updated, key)
+	balance := snapshot.LocalBalance*1000 - int64(sent) - int64(htlc.amtMsat)
+	return balance, balance >= floor.Msat(snapshot.Capacity)
+}
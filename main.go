@@ -2,10 +2,19 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/callebtc/electronwall/config"
+	"github.com/callebtc/electronwall/rules"
 	"github.com/lightningnetwork/lnd/lnrpc"
 	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
 	"github.com/lightningnetwork/lnd/macaroons"
@@ -23,8 +32,24 @@ const (
 )
 
 type App struct {
-	lnd    lndclient
-	myInfo *lnrpc.GetInfoResponse
+	lnd     lndclient
+	myInfo  *lnrpc.GetInfoResponse
+	monitor *monitorStats
+	limiter *rateLimiter
+	// openLimiter limits the channel open requests
+	openLimiter *openLimiter
+	inflight    *inflightTracker
+	// lifecycles links intercepted HTLCs to their outcome
+	lifecycles *lifecycleTracker
+	// reputation outlives the connection to LND
+	reputation *reputationStore
+	channels   *channelCache
+	// blockHeight is refreshed by pollBlockHeight
+	blockHeight atomic.Uint32
+	// fallbacks counts HTLCs that were resolved with the fallback action
+	fallbacks atomic.Uint64
+	// overloads counts HTLCs that were resolved with the overload action
+	overloads atomic.Uint64
 }
 
 func NewApp(ctx context.Context, lnd lndclient) *App {
@@ -32,19 +57,37 @@ func NewApp(ctx context.Context, lnd lndclient) *App {
 	if err != nil {
 		log.Errorf("Could not get my node info: %s", err)
 	}
-	return &App{
-		lnd:    lnd,
-		myInfo: myInfo,
+	if myInfo == nil {
+		myInfo = &lnrpc.GetInfoResponse{}
 	}
+	app := &App{
+		lnd:         lnd,
+		myInfo:      myInfo,
+		monitor:     newMonitorStats(),
+		limiter:     newRateLimiter(),
+		openLimiter: newOpenLimiter(),
+		inflight:    newInflightTracker(),
+		lifecycles:  newLifecycleTracker(),
+		reputation:  newReputationStore(),
+		channels:    newChannelCache(),
+	}
+	app.blockHeight.Store(myInfo.GetBlockHeight())
+	for _, chain := range myInfo.GetChains() {
+		if network := config.Current().Network; chain.Network != network {
+			log.Warnf("LND runs on %s, but the configured network is %s", chain.Network, network)
+		}
+	}
+	return app
 }
 
 // gets the lnd grpc connection
 func getClientConnection(ctx context.Context) (*grpc.ClientConn, error) {
-	creds, err := credentials.NewClientTLSFromFile(config.Configuration.TLSPath, "")
+	conf := config.Current()
+	creds, err := credentials.NewClientTLSFromFile(conf.TLSPath, "")
 	if err != nil {
 		return nil, err
 	}
-	macBytes, err := ioutil.ReadFile(config.Configuration.MacaroonPath)
+	macBytes, err := ioutil.ReadFile(conf.MacaroonPath)
 	if err != nil {
 		return nil, err
 	}
@@ -61,8 +104,8 @@ func getClientConnection(ctx context.Context) (*grpc.ClientConn, error) {
 		grpc.WithBlock(),
 		grpc.WithPerRPCCredentials(cred),
 	}
-	log.Infof("Connecting to %s", config.Configuration.Host)
-	conn, err := grpc.DialContext(ctx, config.Configuration.Host, opts...)
+	log.Infof("Connecting to %s", conf.Host)
+	conn, err := grpc.DialContext(ctx, conf.Host, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -85,9 +128,85 @@ func newLndClient(ctx context.Context) (*LndClient, error) {
 }
 
 func main() {
-	SetLogger(config.Configuration.Debug, config.Configuration.LogJson)
+	configPath := flag.String("config", envOr(config.EnvPrefix+"_CONFIG", "config.yaml"), "path to the configuration file")
+	flag.StringVar(&config.Overrides.RulesDir, "rules-dir", "", "directory containing ChannelAccept.js and HtlcForward.js")
+	flag.StringVar(&config.Overrides.LndDir, "lnddir", "", "LND directory to take tls.cert and admin.macaroon from")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [check-config|reputation]\n\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Commands:\n  check-config\tvalidate the config and the rule scripts without connecting to LND\n  reputation\tprint the saved reputation of all peers\n\nFlags:\n")
+		flag.PrintDefaults()
+	}
+
+	// the command can be given before or after the flags
+	args := os.Args[1:]
+	command := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	flag.CommandLine.Parse(args)
+	if command == "" {
+		command = flag.Arg(0)
+	}
+
+	switch command {
+	case "":
+	case "reputation":
+		if err := printReputation(*configPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	case "check-config":
+		if err := checkConfig(*configPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s is valid\n", *configPath)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", command)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := config.Init(*configPath); err != nil {
+		log.Fatalf("Could not load config: %v", err)
+	}
+	rules.Dir = config.Current().RulesDir
+
+	SetLogger(config.Current().Debug, config.Current().LogJson)
 	Welcome()
-	ctx := context.Background()
+	// interrupting or terminating the process shuts it down cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// reload the configuration on change and on SIGHUP
+	go config.Watch(ctx)
+	go reloadOnSignal(ctx)
+
+	// compile the rule scripts once and recompile them on change
+	if err := rules.Load(); err != nil && config.Current().ApiRules.Apply {
+		log.Errorf("[rules] %v", err)
+	}
+	rules.Watch(ctx)
+
+	// peer reputations are kept across restarts
+	reputationFile := config.Current().ForwardReputation.File
+	reputation := newReputationStore()
+	if err := reputation.load(reputationFile); err != nil {
+		log.Errorf("[reputation] Could not load %s, starting over: %v", reputationFile, err)
+	}
+	saved := make(chan struct{})
+	go func() {
+		reputation.persist(ctx, reputationFile, time.Minute)
+		close(saved)
+	}()
+	// persist saves once more when ctx is done, wait for it before exiting
+	defer func() {
+		stop()
+		<-saved
+	}()
+
 	for {
 		lnd, err := newLndClient(ctx)
 		if err != nil {
@@ -96,6 +215,7 @@ func main() {
 		}
 
 		app := NewApp(ctx, lnd)
+		app.reputation = reputation
 
 		if len(app.myInfo.Alias) > 0 {
 			log.Infof("Connected to %s (%s)", app.myInfo.Alias, trimPubKey([]byte(app.myInfo.IdentityPubkey)))
@@ -103,22 +223,64 @@ func main() {
 			log.Infof("Connected to %s", app.myInfo.IdentityPubkey)
 		}
 
+		// summarize would-be rejections of monitor mode and keep the block
+		// height and the channels current until this connection ends
+		connCtx, stopConn := context.WithCancel(ctx)
+		go app.monitor.logSummaries(connCtx, time.Duration(config.Current().MonitorSummaryInterval)*time.Second)
+		go app.pollBlockHeight(connCtx, blockHeightInterval)
+		go app.keepChannelsFresh(connCtx)
+
 		var wg sync.WaitGroup
 		ctx = context.WithValue(ctx, ctxKeyWaitGroup, &wg)
 		wg.Add(2)
 
 		// channel acceptor
-		if config.Configuration.ChannelMode != "passthrough" {
+		conf := config.Current()
+		if conf.ChannelMode != "passthrough" {
 			app.DispatchChannelAcceptor(ctx)
 		}
 
 		// htlc acceptor
-		if config.Configuration.ForwardMode != "passthrough" {
+		if conf.ForwardMode != "passthrough" {
 			app.DispatchHTLCAcceptor(ctx)
 		}
 
 		wg.Wait()
+		stopConn()
+		if ctx.Err() != nil {
+			log.Info("Shutting down.")
+			return
+		}
 		log.Info("All routines stopped. Waiting for new connection.")
 	}
 
 }
+
+// checkConfig validates the configuration at path and compiles the rule
+// scripts it refers to
+func checkConfig(path string) error {
+	c, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+	rules.Dir = c.RulesDir
+	return rules.Load()
+}
+
+// reloadOnSignal reloads the configuration whenever the process receives SIGHUP
+func reloadOnSignal(ctx context.Context) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sighup:
+			log.Infof("[config] SIGHUP received, reloading %s", config.ConfigPath)
+			if err := config.Reload(); err != nil {
+				log.Errorf("[config] Keeping previous configuration, reload failed: %v", err)
+			}
+		}
+	}
+}
@@ -3,15 +3,41 @@ package main
 import (
 	"context"
 	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/callebtc/electronwall/config"
+	"github.com/callebtc/electronwall/rules"
+	"github.com/callebtc/electronwall/types"
 	"github.com/lightningnetwork/lnd/lnrpc"
 	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
 	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/require"
 )
 
+func TestMain(m *testing.M) {
+	if err := config.Init("config.yaml"); err != nil {
+		log.Fatalf("Could not load config: %v", err)
+	}
+	os.Exit(m.Run())
+}
+
+// setConfig applies fn to a copy of the active configuration and activates it
+func setConfig(t *testing.T, fn func(c *config.Config)) {
+	previous := config.Current()
+	t.Cleanup(func() {
+		require.NoError(t, config.Set(previous.Copy()))
+	})
+	c := previous.Copy()
+	fn(c)
+	require.NoError(t, config.Set(c))
+}
+
 func TestApp(t *testing.T) {
 	client := newLndclientMock()
 	ctx, cancel := context.WithCancel(context.Background())
@@ -25,6 +51,17 @@ func TestApp(t *testing.T) {
 	cancel()
 }
 
+func TestApp_NoNodeInfo(t *testing.T) {
+	client := newLndclientMock()
+	client.infoErr = fmt.Errorf("unavailable")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := NewApp(ctx, client)
+	require.NotNil(t, app.myInfo)
+	require.Zero(t, app.blockHeight.Load())
+}
+
 // --------------- HTLC Forward tests ---------------
 
 // both keys match: should be denied
@@ -35,8 +72,10 @@ func TestHTLCDenylist_BothMatch(t *testing.T) {
 
 	app := NewApp(ctx, client)
 
-	config.Configuration.ForwardMode = "denylist"
-	config.Configuration.ForwardDenylist = []string{"700762x1327x1->690757x1005x1"}
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "denylist"
+		c.ForwardDenylist = []string{"700762x1327x1->690757x1005x1"}
+	})
 
 	app.DispatchHTLCAcceptor(ctx)
 
@@ -69,8 +108,10 @@ func TestHTLCDenylist_BothNoMatch(t *testing.T) {
 
 	app := NewApp(ctx, client)
 
-	config.Configuration.ForwardMode = "denylist"
-	config.Configuration.ForwardDenylist = []string{"700762x1327x1->690757x1005x1"}
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "denylist"
+		c.ForwardDenylist = []string{"700762x1327x1->690757x1005x1"}
+	})
 
 	app.DispatchHTLCAcceptor(ctx)
 
@@ -103,12 +144,16 @@ func TestHTLCDenylist_WildCardOutBothMatch(t *testing.T) {
 
 	app := NewApp(ctx, client)
 
-	config.Configuration.ForwardMode = "denylist"
-	config.Configuration.ForwardDenylist = []string{"700762x1327x1->690757x1005x1"}
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "denylist"
+		c.ForwardDenylist = []string{"700762x1327x1->690757x1005x1"}
+	})
 
 	app.DispatchHTLCAcceptor(ctx)
 
-	config.Configuration.ForwardDenylist = []string{"700762x1327x1->*"}
+	setConfig(t, func(c *config.Config) {
+		c.ForwardDenylist = []string{"700762x1327x1->*"}
+	})
 
 	key := &routerrpc.CircuitKey{
 		ChanId: 770495967390531585,
@@ -139,14 +184,18 @@ func TestHTLCDenylist_WildCardOutNoMatch(t *testing.T) {
 
 	app := NewApp(ctx, client)
 
-	config.Configuration.ForwardMode = "denylist"
-	config.Configuration.ForwardDenylist = []string{"700762x1327x1->690757x1005x1"}
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "denylist"
+		c.ForwardDenylist = []string{"700762x1327x1->690757x1005x1"}
+	})
 
 	app.DispatchHTLCAcceptor(ctx)
 
 	// wildcard out, first key doesn't match: should be allowed
 
-	config.Configuration.ForwardDenylist = []string{"700762x1327x1->*"}
+	setConfig(t, func(c *config.Config) {
+		c.ForwardDenylist = []string{"700762x1327x1->*"}
+	})
 
 	key := &routerrpc.CircuitKey{
 		ChanId: 759495353533530113,
@@ -178,11 +227,15 @@ func TestHTLCAllowlist_BothMatch(t *testing.T) {
 
 	app.DispatchHTLCAcceptor(ctx)
 
-	config.Configuration.ForwardMode = "allowlist"
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "allowlist"
+	})
 
 	// both keys correct: should be allowed
-	config.Configuration.ForwardAllowlist = []string{"700762x1327x1->690757x1005x1"}
-	log.Tracef("[test] Mode: %s, Rules: %v", config.Configuration.ForwardMode, config.Configuration.ForwardAllowlist)
+	setConfig(t, func(c *config.Config) {
+		c.ForwardAllowlist = []string{"700762x1327x1->690757x1005x1"}
+	})
+	log.Tracef("[test] Mode: %s, Rules: %v", config.Current().ForwardMode, config.Current().ForwardAllowlist)
 
 	key := &routerrpc.CircuitKey{
 		ChanId: 770495967390531585,
@@ -214,10 +267,14 @@ func TestHTLCAllowlist_BothNonMatch(t *testing.T) {
 
 	app.DispatchHTLCAcceptor(ctx)
 
-	config.Configuration.ForwardMode = "allowlist"
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "allowlist"
+	})
 	// both keys wrong: should be denied
-	config.Configuration.ForwardAllowlist = []string{"700762x1327x1->690757x1005x1"}
-	log.Tracef("[test] Mode: %s, Rules: %v", config.Configuration.ForwardMode, config.Configuration.ForwardAllowlist)
+	setConfig(t, func(c *config.Config) {
+		c.ForwardAllowlist = []string{"700762x1327x1->690757x1005x1"}
+	})
+	log.Tracef("[test] Mode: %s, Rules: %v", config.Current().ForwardMode, config.Current().ForwardAllowlist)
 
 	key := &routerrpc.CircuitKey{
 		ChanId: 123456789876543210,
@@ -248,10 +305,14 @@ func TestHTLCAllowlist_Wildcard(t *testing.T) {
 
 	app.DispatchHTLCAcceptor(ctx)
 
-	config.Configuration.ForwardMode = "allowlist"
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "allowlist"
+	})
 	// wildcard: should be allowed
-	config.Configuration.ForwardAllowlist = []string{"*"}
-	log.Tracef("[test] Mode: %s, Rules: %v", config.Configuration.ForwardMode, config.Configuration.ForwardAllowlist)
+	setConfig(t, func(c *config.Config) {
+		c.ForwardAllowlist = []string{"*"}
+	})
+	log.Tracef("[test] Mode: %s, Rules: %v", config.Current().ForwardMode, config.Current().ForwardAllowlist)
 	key := &routerrpc.CircuitKey{
 		ChanId: 123456789876543210,
 		HtlcId: 1337000,
@@ -281,11 +342,15 @@ func TestHTLCAllowlist_WildcardIn(t *testing.T) {
 
 	app.DispatchHTLCAcceptor(ctx)
 
-	config.Configuration.ForwardMode = "allowlist"
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "allowlist"
+	})
 	// wildcard in: should be allowed
 
-	config.Configuration.ForwardAllowlist = []string{"*->690757x1005x1"}
-	log.Tracef("[test] Mode: %s, Rules: %v", config.Configuration.ForwardMode, config.Configuration.ForwardAllowlist)
+	setConfig(t, func(c *config.Config) {
+		c.ForwardAllowlist = []string{"*->690757x1005x1"}
+	})
+	log.Tracef("[test] Mode: %s, Rules: %v", config.Current().ForwardMode, config.Current().ForwardAllowlist)
 
 	key := &routerrpc.CircuitKey{
 		ChanId: 123456789876543210,
@@ -316,10 +381,14 @@ func TestHTLCAllowlist_WildcardOut(t *testing.T) {
 
 	app.DispatchHTLCAcceptor(ctx)
 
-	config.Configuration.ForwardMode = "allowlist"
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "allowlist"
+	})
 	// wildcard out: should be allowed
-	config.Configuration.ForwardAllowlist = []string{"700762x1327x1->*"}
-	log.Tracef("[test] Mode: %s, Rules: %v", config.Configuration.ForwardMode, config.Configuration.ForwardAllowlist)
+	setConfig(t, func(c *config.Config) {
+		c.ForwardAllowlist = []string{"700762x1327x1->*"}
+	})
+	log.Tracef("[test] Mode: %s, Rules: %v", config.Current().ForwardMode, config.Current().ForwardAllowlist)
 
 	key := &routerrpc.CircuitKey{
 		ChanId: 770495967390531585,
@@ -350,10 +419,14 @@ func TestHTLCAllowlist_WildcardOutWrongKeyIn(t *testing.T) {
 
 	app.DispatchHTLCAcceptor(ctx)
 
-	config.Configuration.ForwardMode = "allowlist"
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "allowlist"
+	})
 	// wildcard out but wrong in key: should be denied
-	config.Configuration.ForwardAllowlist = []string{"700762x1327x1->*"}
-	log.Tracef("[test] Mode: %s, Rules: %v", config.Configuration.ForwardMode, config.Configuration.ForwardAllowlist)
+	setConfig(t, func(c *config.Config) {
+		c.ForwardAllowlist = []string{"700762x1327x1->*"}
+	})
+	log.Tracef("[test] Mode: %s, Rules: %v", config.Current().ForwardMode, config.Current().ForwardAllowlist)
 
 	key := &routerrpc.CircuitKey{
 		ChanId: 123456789876543210,
@@ -384,10 +457,14 @@ func TestHTLCAllowlist_WildcardIn_WrongKeyOut(t *testing.T) {
 
 	app.DispatchHTLCAcceptor(ctx)
 
-	config.Configuration.ForwardMode = "allowlist"
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "allowlist"
+	})
 	// wildcard in but wrong out key: should be denied
-	config.Configuration.ForwardAllowlist = []string{"*->700762x1327x1"}
-	log.Tracef("[test] Mode: %s, Rules: %v", config.Configuration.ForwardMode, config.Configuration.ForwardAllowlist)
+	setConfig(t, func(c *config.Config) {
+		c.ForwardAllowlist = []string{"*->700762x1327x1"}
+	})
+	log.Tracef("[test] Mode: %s, Rules: %v", config.Current().ForwardMode, config.Current().ForwardAllowlist)
 
 	key := &routerrpc.CircuitKey{
 		ChanId: 123456789876543210,
@@ -418,10 +495,14 @@ func TestHTLCAllowlist_WildcardBoth(t *testing.T) {
 
 	app.DispatchHTLCAcceptor(ctx)
 
-	config.Configuration.ForwardMode = "allowlist"
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "allowlist"
+	})
 	// wildcard both: should be allowed
-	config.Configuration.ForwardAllowlist = []string{"*->*"}
-	log.Tracef("[test] Mode: %s, Rules: %v", config.Configuration.ForwardMode, config.Configuration.ForwardAllowlist)
+	setConfig(t, func(c *config.Config) {
+		c.ForwardAllowlist = []string{"*->*"}
+	})
+	log.Tracef("[test] Mode: %s, Rules: %v", config.Current().ForwardMode, config.Current().ForwardAllowlist)
 
 	key := &routerrpc.CircuitKey{
 		ChanId: 123456789876543210,
@@ -445,124 +526,1873 @@ func TestHTLCAllowlist_WildcardBoth(t *testing.T) {
 
 }
 
-// --------------- Channel accept tests ---------------
+// the first matching rule of the chain decides, otherwise the default applies
+func TestHTLCChain_FirstMatchWins(t *testing.T) {
+	client := newLndclientMock()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-func TestChannelAllowlist_CorrectKey(t *testing.T) {
+	app := NewApp(ctx, client)
+
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "chain"
+		c.ForwardChain = []string{
+			"deny 700762x1327x1->690757x1005x1",
+			"allow *->690757x1005x1",
+			"allow 690757x1005x1",
+		}
+		c.ForwardDefault = "deny"
+	})
+
+	app.DispatchHTLCAcceptor(ctx)
+
+	forward := func(in, out uint64) routerrpc.ResolveHoldForwardAction {
+		client.htlcInterceptorRequests <- &routerrpc.ForwardHtlcInterceptRequest{
+			IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: in, HtlcId: 1337000},
+			OutgoingRequestedChanId: out,
+			OutgoingAmountMsat:      99999999,
+		}
+		return (<-client.htlcInterceptorResponses).Action
+	}
+
+	// denied by the first rule although the second one matches too
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_FAIL, forward(770495967390531585, 759495353533530113))
+	// allowed by the second rule
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_RESUME, forward(123456789876543210, 759495353533530113))
+	// allowed by the third rule
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_RESUME, forward(759495353533530113, 770495967390531585))
+	// no rule matches: default
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_FAIL, forward(770495967390531585, 123456789876543210))
+}
+
+func TestChannelChain_FirstMatchWins(t *testing.T) {
+	client := newLndclientMock()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := NewApp(ctx, client)
+	pubkey_str := "03006fcf3312dae8d068ea297f58e2bd00ec1ffe214b793eda46966b6294a53ce6"
+	setConfig(t, func(c *config.Config) {
+		c.ChannelMode = "chain"
+		c.ChannelChain = []string{"deny " + pubkey_str, "allow *"}
+	})
+
+	pubkey, _ := hex.DecodeString(pubkey_str)
+	accept, _, err := app.channelAcceptListDecision(config.Current(), &lnrpc.ChannelAcceptRequest{NodePubkey: pubkey})
+	require.NoError(t, err)
+	require.False(t, accept)
+
+	other, _ := hex.DecodeString("02853f9c1d15d479b433039885373b681683b84bb73e86dff861bee6697c17c1de")
+	accept, _, err = app.channelAcceptListDecision(config.Current(), &lnrpc.ChannelAcceptRequest{NodePubkey: other})
+	require.NoError(t, err)
+	require.True(t, accept)
+}
+
+func TestChannelConstraints(t *testing.T) {
+	pubkey_str := "03006fcf3312dae8d068ea297f58e2bd00ec1ffe214b793eda46966b6294a53ce6"
+	minFunding, maxPush, maxCsv := uint64(1000000), uint64(0), uint32(1008)
+	peerMinFunding := uint64(100000)
+	setConfig(t, func(c *config.Config) {
+		c.ChannelConstraintsPolicy = config.ChannelConstraintsPolicy{
+			ChannelConstraintsConfig: config.ChannelConstraintsConfig{
+				MinFundingSat: &minFunding,
+				MaxPushMsat:   &maxPush,
+				ChannelFlags:  "public",
+				MaxCsvDelay:   &maxCsv,
+			},
+			Peers: []config.PeerConstraintsConfig{
+				{Peer: pubkey_str, ChannelConstraintsConfig: config.ChannelConstraintsConfig{MinFundingSat: &peerMinFunding}},
+			},
+		}
+	})
+
+	other, _ := hex.DecodeString("02853f9c1d15d479b433039885373b681683b84bb73e86dff861bee6697c17c1de")
+	reasons := checkChannelConstraints(config.Current(), &lnrpc.ChannelAcceptRequest{
+		NodePubkey: other,
+		FundingAmt: 500000,
+		PushAmt:    1000,
+		CsvDelay:   2016,
+	})
+	require.Equal(t, []string{
+		"funding 500000 sat below 1000000 sat",
+		"push 1000 msat above 0 msat",
+		"private channels not accepted",
+		"csv delay 2016 above 1008 blocks",
+	}, reasons)
+
+	// the peer may open smaller channels
+	pubkey, _ := hex.DecodeString(pubkey_str)
+	reasons = checkChannelConstraints(config.Current(), &lnrpc.ChannelAcceptRequest{
+		NodePubkey:   pubkey,
+		FundingAmt:   500000,
+		ChannelFlags: 1,
+		CsvDelay:     144,
+	})
+	require.Empty(t, reasons)
+
+	require.Len(t, rejectMessage([]string{strings.Repeat("x", 300), strings.Repeat("y", 300)}), 500)
+}
+
+func TestChannelConstraints_ZeroConf(t *testing.T) {
+	trusted := "03006fcf3312dae8d068ea297f58e2bd00ec1ffe214b793eda46966b6294a53ce6"
+	setConfig(t, func(c *config.Config) {
+		c.ChannelConstraintsPolicy = config.ChannelConstraintsPolicy{
+			ChannelConstraintsConfig: config.ChannelConstraintsConfig{
+				CommitmentTypes: []string{"anchors", "SCRIPT_ENFORCED_LEASE"},
+			},
+			Peers: []config.PeerConstraintsConfig{
+				{Peer: trusted, ChannelConstraintsConfig: config.ChannelConstraintsConfig{ZeroConf: "grant"}},
+			},
+		}
+	})
+	conf := config.Current()
+
+	other, _ := hex.DecodeString("02853f9c1d15d479b433039885373b681683b84bb73e86dff861bee6697c17c1de")
+	req := &lnrpc.ChannelAcceptRequest{NodePubkey: other, CommitmentType: lnrpc.CommitmentType_STATIC_REMOTE_KEY, WantsZeroConf: true}
+	require.Equal(t, []string{"commitment type STATIC_REMOTE_KEY not accepted", "zero-conf channels not accepted"}, checkChannelConstraints(conf, req))
+	require.False(t, grantsZeroConf(conf, req))
+
+	pubkey, _ := hex.DecodeString(trusted)
+	req = &lnrpc.ChannelAcceptRequest{NodePubkey: pubkey, CommitmentType: lnrpc.CommitmentType_ANCHORS, WantsZeroConf: true}
+	require.Empty(t, checkChannelConstraints(conf, req))
+	require.True(t, grantsZeroConf(conf, req))
+	res := channelAcceptResponse(req, channelParams(conf, req), grantsZeroConf(conf, req))
+	require.True(t, res.ZeroConf)
+	require.Zero(t, res.MinAcceptDepth)
+}
+
+func TestChannelCaps(t *testing.T) {
+	peer := "03006fcf3312dae8d068ea297f58e2bd00ec1ffe214b793eda46966b6294a53ce6"
+	other := "02853f9c1d15d479b433039885373b681683b84bb73e86dff861bee6697c17c1de"
+	client := newLndclientMock()
+	client.channels = []*lnrpc.Channel{
+		{ChanId: 759495353533530113, RemotePubkey: peer, Capacity: 1000000},
+		{ChanId: 770495967390531585, RemotePubkey: other, Capacity: 5000000},
+	}
+	client.pendingChannels = []*lnrpc.PendingChannelsResponse_PendingOpenChannel{
+		{Channel: &lnrpc.PendingChannelsResponse_PendingChannel{RemoteNodePub: peer, Capacity: 500000, Initiator: lnrpc.Initiator_INITIATOR_REMOTE}},
+		{Channel: &lnrpc.PendingChannelsResponse_PendingChannel{RemoteNodePub: other, Capacity: 500000, Initiator: lnrpc.Initiator_INITIATOR_LOCAL}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := NewApp(ctx, client)
+
+	counts, err := app.channelCounts(ctx, peer)
+	require.NoError(t, err)
+	require.Equal(t, types.ChannelCounts{Open: 1, Pending: 1, OpenCapacity: 1000000, PendingCapacity: 500000, PendingInbound: 1}, *counts)
+
+	maxChannels, maxCapacity, maxPending, otherMaxChannels := uint32(2), uint64(2000000), uint32(1), uint32(3)
+	setConfig(t, func(c *config.Config) {
+		c.ChannelCapsPolicy = config.ChannelCapsPolicy{
+			ChannelCapsConfig: config.ChannelCapsConfig{MaxChannels: &maxChannels, MaxCapacitySat: &maxCapacity, MaxPendingInbound: &maxPending},
+			Peers: []config.PeerCapsConfig{
+				{Peer: other, ChannelCapsConfig: config.ChannelCapsConfig{MaxChannels: &otherMaxChannels}},
+			},
+		}
+	})
+	conf := config.Current()
+
+	pubkey, _ := hex.DecodeString(peer)
+	req := &lnrpc.ChannelAcceptRequest{NodePubkey: pubkey, FundingAmt: 1000000}
+	require.Equal(t, []string{
+		"3 channels with the peer, more than 2",
+		"capacity of 2500000 sat with the peer above 2000000 sat",
+		"2 pending inbound channels, more than 1",
+	}, checkChannelCaps(conf, req, counts))
+
+	// the other peer may have one more channel
+	counts, err = app.channelCounts(ctx, other)
+	require.NoError(t, err)
+	pubkey, _ = hex.DecodeString(other)
+	req = &lnrpc.ChannelAcceptRequest{NodePubkey: pubkey, FundingAmt: 100000}
+	require.Equal(t, []string{
+		"capacity of 5600000 sat with the peer above 2000000 sat",
+		"2 pending inbound channels, more than 1",
+	}, checkChannelCaps(conf, req, counts))
+
+	// caps reject requests if the channels could not be counted
+	require.Equal(t, []string{"channels could not be counted"}, checkChannelCaps(conf, req, nil))
+}
+
+func TestChannelRateLimit(t *testing.T) {
+	peer := "03006fcf3312dae8d068ea297f58e2bd00ec1ffe214b793eda46966b6294a53ce6"
+	other := "02853f9c1d15d479b433039885373b681683b84bb73e86dff861bee6697c17c1de"
+	now := time.Unix(1700000000, 0)
+	l := newOpenLimiter()
+	l.now = func() time.Time { return now }
+	rl := config.ChannelRateLimitConfig{PerPeer: 2, Global: 3, Window: 60, DenylistAfter: 2, DenylistDuration: 3600}
+
+	for i := 0; i < 2; i++ {
+		_, ok := l.allow(rl, peer)
+		require.True(t, ok)
+	}
+	reason, ok := l.allow(rl, peer)
+	require.False(t, ok)
+	require.Equal(t, "rate limit: more than 2 requests from the peer in 60s", reason)
+
+	// the rejected request does not count toward the global limit
+	_, ok = l.allow(rl, other)
+	require.True(t, ok)
+	reason, ok = l.allow(rl, "02ab")
+	require.False(t, ok)
+	require.Equal(t, "rate limit: more than 3 requests in 60s", reason)
+
+	// the second rejection within the window denies the peer
+	reason, ok = l.allow(rl, peer)
+	require.False(t, ok)
+	require.Equal(t, "rate limit: denied for 3600s", reason)
+	now = now.Add(time.Minute)
+	_, ok = l.allow(rl, peer)
+	require.False(t, ok)
+
+	// the window slides, but the peer stays denied until the denial expires
+	_, ok = l.allow(rl, other)
+	require.True(t, ok)
+	now = now.Add(time.Hour)
+	_, ok = l.allow(rl, peer)
+	require.True(t, ok)
+}
+
+func TestChannelRateLimit_Acceptor(t *testing.T) {
 	client := newLndclientMock()
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	app := NewApp(ctx, client)
 	pubkey_str := "03006fcf3312dae8d068ea297f58e2bd00ec1ffe214b793eda46966b6294a53ce6"
-	config.Configuration.ChannelMode = "allowlist"
-	config.Configuration.ChannelAllowlist = []string{pubkey_str}
+	setConfig(t, func(c *config.Config) {
+		c.ChannelRateLimit = config.ChannelRateLimitConfig{PerPeer: 1}
+	})
+	_, ok := app.openLimiter.allow(config.Current().ChannelRateLimit, pubkey_str)
+	require.True(t, ok)
 
 	app.DispatchChannelAcceptor(ctx)
 
-	// correct key: should be allowed
+	// rejected without looking up the peer
 	pubkey, _ := hex.DecodeString(pubkey_str)
 	client.channelAcceptorRequests <- &lnrpc.ChannelAcceptRequest{
 		NodePubkey:    pubkey,
 		FundingAmt:    1337000,
 		PendingChanId: []byte("759495353533530113"),
 	}
-
 	resp := <-client.channelAcceptorResponses
-	require.Equal(t, resp.Accept, true)
+	require.False(t, resp.Accept)
+	require.Equal(t, "rate limit: more than 1 requests from the peer in 600s", resp.Error)
+
+	// monitor mode accepts with validated parameters
+	reserve := uint64(100)
+	setConfig(t, func(c *config.Config) {
+		c.ChannelRateLimit = config.ChannelRateLimitConfig{PerPeer: 1}
+		c.ChannelMode = "monitor"
+		c.ChannelParamsPolicy = config.ChannelParamsPolicy{ChannelParamsConfig: config.ChannelParamsConfig{ReserveSat: &reserve}}
+	})
+	client.channelAcceptorRequests <- &lnrpc.ChannelAcceptRequest{
+		NodePubkey:     pubkey,
+		FundingAmt:     1337000,
+		PendingChanId:  []byte("759495353533530114"),
+		ChannelReserve: 20000,
+		DustLimit:      354,
+	}
+	resp = <-client.channelAcceptorResponses
+	require.True(t, resp.Accept)
+	require.Equal(t, uint64(20000), resp.ReserveSat)
 
+	// monitor mode grants zero-conf, which LND could not downgrade
+	require.Equal(t, config.ZeroConfReject, config.Current().ChannelConstraints(pubkey_str).ZeroConf)
+	client.channelAcceptorRequests <- &lnrpc.ChannelAcceptRequest{
+		NodePubkey:    pubkey,
+		FundingAmt:    1337000,
+		PendingChanId: []byte("759495353533530115"),
+		WantsZeroConf: true,
+	}
+	resp = <-client.channelAcceptorResponses
+	require.True(t, resp.Accept)
+	require.True(t, resp.ZeroConf)
+	require.Zero(t, resp.MinAcceptDepth)
 }
-func TestChannelAllowlist_WrongKey(t *testing.T) {
+
+func TestChannelParams(t *testing.T) {
+	partner := "03006fcf3312dae8d068ea297f58e2bd00ec1ffe214b793eda46966b6294a53ce6"
+	htlcs, minHtlc, partnerHtlcs := uint32(30), uint64(10000), uint32(483)
+	setConfig(t, func(c *config.Config) {
+		c.ApiRules.Apply = true
+		c.ChannelParamsPolicy = config.ChannelParamsPolicy{
+			ChannelParamsConfig: config.ChannelParamsConfig{MaxHtlcCount: &htlcs, MinHtlcIn: &minHtlc},
+			Tiers: []config.ChannelParamsTierConfig{
+				{Name: "partners", Peers: []string{partner}, ChannelParamsConfig: config.ChannelParamsConfig{MaxHtlcCount: &partnerHtlcs}},
+			},
+		}
+	})
+	previousDir := rules.Dir
+	t.Cleanup(func() {
+		rules.Dir = previousDir
+		require.NoError(t, rules.Load())
+	})
+	rules.Dir = t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(rules.Dir, "ChannelAccept.js"), []byte(
+		"if (ChannelAccept.Params.Tier == '') { ChannelAccept.Params.MinHtlcIn = 20000 }; true"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(rules.Dir, "HtlcForward.js"), []byte("true"), 0600))
+	require.NoError(t, rules.Load())
+
+	evaluate := func(pubkey string) *types.ChannelAcceptParams {
+		node, _ := hex.DecodeString(pubkey)
+		req := &lnrpc.ChannelAcceptRequest{NodePubkey: node, FundingAmt: 1000000, MaxAcceptedHtlcs: 483, MinHtlc: 1}
+		event := types.ChannelAcceptEvent{Event: req, Params: channelParams(config.Current(), req)}
+		result, err := rules.Evaluate(context.Background(), config.Current(), event)
+		require.NoError(t, err)
+		require.Equal(t, rules.Allow, result)
+		require.Empty(t, validateChannelParams(event.Params, req, config.NetworkMainnet))
+		return event.Params
+	}
+
+	params := evaluate(partner)
+	require.Equal(t, "partners", params.Tier)
+	require.Equal(t, uint32(483), params.MaxHtlcCount)
+	require.Equal(t, uint64(10000), params.MinHtlcIn)
+
+	// the rule raises the minimum HTLC of unknown peers
+	params = evaluate("02853f9c1d15d479b433039885373b681683b84bb73e86dff861bee6697c17c1de")
+	require.Equal(t, uint32(30), params.MaxHtlcCount)
+	require.Equal(t, uint64(20000), params.MinHtlcIn)
+
+	// parameters out of bounds fall back to the request
+	req := &lnrpc.ChannelAcceptRequest{FundingAmt: 100000, DustLimit: 354, MaxAcceptedHtlcs: 483, ChannelReserve: 1000}
+	params = &types.ChannelAcceptParams{CsvDelay: 4032, MaxHtlcCount: 1000, ReserveSat: 100, UpfrontShutdown: "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx"}
+	require.Len(t, validateChannelParams(params, req, config.NetworkMainnet), 4)
+	require.Zero(t, params.CsvDelay)
+	require.Equal(t, uint32(483), params.MaxHtlcCount)
+	require.Equal(t, uint64(1000), params.ReserveSat)
+	require.Empty(t, params.UpfrontShutdown)
+}
+
+// monitor mode resumes HTLCs that would be denied and counts them
+func TestHTLCMonitor_WouldDeny(t *testing.T) {
 	client := newLndclientMock()
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	app := NewApp(ctx, client)
 
-	pubkey_str := "03006fcf3312dae8d068ea297f58e2bd00ec1ffe214b793eda46966b6294a53ce6"
-	config.Configuration.ChannelMode = "allowlist"
-	config.Configuration.ChannelAllowlist = []string{pubkey_str}
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "monitor"
+		c.ForwardMonitorMode = "denylist"
+		c.ForwardDenylist = []string{"700762x1327x1"}
+	})
 
-	app.DispatchChannelAcceptor(ctx)
-	// wrong key: should be denied
+	app.DispatchHTLCAcceptor(ctx)
 
-	client.channelAcceptorRequests <- &lnrpc.ChannelAcceptRequest{
-		NodePubkey:    []byte("WRONG-KEY"),
-		FundingAmt:    1337000,
-		PendingChanId: []byte("759495353533530113"),
+	client.htlcInterceptorRequests <- &routerrpc.ForwardHtlcInterceptRequest{
+		IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: 770495967390531585, HtlcId: 1337000},
+		OutgoingRequestedChanId: 759495353533530113,
+		OutgoingAmountMsat:      99999999,
 	}
 
-	resp := <-client.channelAcceptorResponses
-	require.Equal(t, resp.Accept, false)
+	resp := <-client.htlcInterceptorResponses
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_RESUME, resp.Action)
+
+	app.monitor.mu.Lock()
+	defer app.monitor.mu.Unlock()
+	require.Equal(t, map[string]int{"list rule 1: deny 700762x1327x1": 1}, app.monitor.forwards)
 }
-func TestChannelAllowlist_Wildcard(t *testing.T) {
+
+// useHtlcForwardRule activates a temporary HtlcForward.js with the given script
+func useHtlcForwardRule(t *testing.T, script string) {
+	previousDir := rules.Dir
+	t.Cleanup(func() {
+		rules.Dir = previousDir
+		require.NoError(t, rules.Load())
+	})
+	rules.Dir = t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(rules.Dir, "ChannelAccept.js"), []byte("true"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(rules.Dir, "HtlcForward.js"), []byte(script), 0600))
+	require.NoError(t, rules.Load())
+}
+
+// a rule error resolves the HTLC with the fallback action
+func TestHTLCFallback_RuleError(t *testing.T) {
 	client := newLndclientMock()
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	app := NewApp(ctx, client)
 
-	app.DispatchChannelAcceptor(ctx)
+	useHtlcForwardRule(t, "42")
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "denylist"
+		c.ForwardDenylist = []string{}
+		c.ApiRules.Apply = true
+		c.ForwardFallbackAction = "resume"
+	})
 
-	pubkey_str := "03006fcf3312dae8d068ea297f58e2bd00ec1ffe214b793eda46966b6294a53ce6"
-	// wildcard: should be allowed
-	config.Configuration.ChannelMode = "allowlist"
-	config.Configuration.ChannelAllowlist = []string{"*"}
+	app.DispatchHTLCAcceptor(ctx)
 
-	pubkey, _ := hex.DecodeString(pubkey_str)
-	client.channelAcceptorRequests <- &lnrpc.ChannelAcceptRequest{
-		NodePubkey:    pubkey,
-		FundingAmt:    1337000,
-		PendingChanId: []byte("759495353533530113"),
+	client.htlcInterceptorRequests <- &routerrpc.ForwardHtlcInterceptRequest{
+		IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: 770495967390531585, HtlcId: 1337000},
+		OutgoingRequestedChanId: 759495353533530113,
 	}
 
-	resp := <-client.channelAcceptorResponses
-	require.Equal(t, resp.Accept, true)
+	resp := <-client.htlcInterceptorResponses
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_RESUME, resp.Action)
+	require.Equal(t, uint64(1), app.fallbacks.Load())
 }
 
-func TestChannelDenylist_Match(t *testing.T) {
+// a rule that does not finish in time is interrupted and the HTLC resolved
+// with the fallback action
+func TestHTLCFallback_Deadline(t *testing.T) {
 	client := newLndclientMock()
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	app := NewApp(ctx, client)
-	pubkey_str := "03006fcf3312dae8d068ea297f58e2bd00ec1ffe214b793eda46966b6294a53ce6"
-	config.Configuration.ChannelMode = "denylist"
-	config.Configuration.ChannelDenylist = []string{pubkey_str}
 
-	app.DispatchChannelAcceptor(ctx)
+	useHtlcForwardRule(t, "while (true) {}")
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "denylist"
+		c.ForwardDenylist = []string{}
+		c.ApiRules.Apply = true
+		c.ForwardFallbackAction = "fail"
+		c.ForwardEvaluationTimeout = 1
+	})
 
-	// should be denied
-	pubkey, _ := hex.DecodeString(pubkey_str)
-	client.channelAcceptorRequests <- &lnrpc.ChannelAcceptRequest{
-		NodePubkey:    []byte(pubkey),
-		FundingAmt:    1337000,
-		PendingChanId: []byte("759495353533530113"),
+	app.DispatchHTLCAcceptor(ctx)
+
+	client.htlcInterceptorRequests <- &routerrpc.ForwardHtlcInterceptRequest{
+		IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: 770495967390531585, HtlcId: 1337000},
+		OutgoingRequestedChanId: 759495353533530113,
 	}
 
-	resp := <-client.channelAcceptorResponses
-	require.Equal(t, resp.Accept, false)
+	resp := <-client.htlcInterceptorResponses
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_FAIL, resp.Action)
+	require.Equal(t, uint64(1), app.fallbacks.Load())
 }
 
-func TestChannelAllowlist_Match(t *testing.T) {
+// monitor mode resumes an HTLC whose rule missed the deadline and only
+// records the fallback
+func TestHTLCFallback_Monitor(t *testing.T) {
 	client := newLndclientMock()
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	app := NewApp(ctx, client)
-	pubkey_str := "03006fcf3312dae8d068ea297f58e2bd00ec1ffe214b793eda46966b6294a53ce6"
-	config.Configuration.ChannelMode = "allowlist"
-	config.Configuration.ChannelAllowlist = []string{pubkey_str}
 
-	app.DispatchChannelAcceptor(ctx)
+	useHtlcForwardRule(t, "while (true) {}")
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "monitor"
+		c.ForwardMonitorMode = "denylist"
+		c.ForwardDenylist = []string{}
+		c.ApiRules.Apply = true
+		c.ForwardFallbackAction = "fail"
+		c.ForwardEvaluationTimeout = 1
+	})
 
-	// should be allowed
-	pubkey, _ := hex.DecodeString(pubkey_str)
-	client.channelAcceptorRequests <- &lnrpc.ChannelAcceptRequest{
-		NodePubkey:    []byte(pubkey),
-		FundingAmt:    1337000,
-		PendingChanId: []byte("759495353533530113"),
+	app.DispatchHTLCAcceptor(ctx)
+
+	client.htlcInterceptorRequests <- &routerrpc.ForwardHtlcInterceptRequest{
+		IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: 770495967390531585, HtlcId: 1337000},
+		OutgoingRequestedChanId: 759495353533530113,
 	}
 
-	resp := <-client.channelAcceptorResponses
-	require.Equal(t, resp.Accept, true)
+	resp := <-client.htlcInterceptorResponses
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_RESUME, resp.Action)
+	require.Equal(t, uint64(1), app.fallbacks.Load())
+	app.monitor.mu.Lock()
+	require.Equal(t, 1, app.monitor.forwards["fallback"])
+	app.monitor.mu.Unlock()
+}
+
+// a decision that finishes after the fallback resolved the HTLC reserves
+// nothing and records no second lifecycle
+func TestHTLCFallback_LateDecision(t *testing.T) {
+	client := newLndclientMock()
+	client.lookupDelay = 600 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := NewApp(ctx, client)
+
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "denylist"
+		c.ForwardDenylist = []string{}
+		c.ForwardFallbackAction = "fail"
+		c.ForwardEvaluationTimeout = 1
+	})
+
+	app.DispatchHTLCAcceptor(ctx)
+
+	client.htlcInterceptorRequests <- &routerrpc.ForwardHtlcInterceptRequest{
+		IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: 770495967390531585, HtlcId: 1337000},
+		OutgoingRequestedChanId: 759495353533530113,
+	}
+
+	resp := <-client.htlcInterceptorResponses
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_FAIL, resp.Action)
+	require.Equal(t, uint64(1), app.fallbacks.Load())
+
+	// let the decision finish
+	time.Sleep(time.Second)
+	_, channel := app.inflight.totals("", 759495353533530113)
+	require.Zero(t, channel.htlcs)
+	app.lifecycles.mu.Lock()
+	require.Empty(t, app.lifecycles.pending)
+	app.lifecycles.mu.Unlock()
+
+	// a decision that lost the claim reserves nothing even before the
+	// deadline
+	_, err := app.htlcDecision(ctx, config.Current(), &routerrpc.ForwardHtlcInterceptRequest{
+		IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: 770495967390531585, HtlcId: 1337001},
+		OutgoingRequestedChanId: 759495353533530113,
+	}, func() bool { return false })
+	require.ErrorIs(t, err, errDecisionTooLate)
+	_, channel = app.inflight.totals("", 759495353533530113)
+	require.Zero(t, channel.htlcs)
+}
+
+// HTLCs that do not fit into the queue are resolved with the overload action
+// right away while the worker is busy
+func TestHTLCOverload_QueueFull(t *testing.T) {
+	client := newLndclientMock()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := NewApp(ctx, client)
+
+	useHtlcForwardRule(t, "while (true) {}")
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "denylist"
+		c.ForwardDenylist = []string{}
+		c.ApiRules.Apply = true
+		c.ForwardFallbackAction = "fail"
+		c.ForwardEvaluationTimeout = 1
+		c.ForwardWorkers = 1
+		c.ForwardQueueSize = 1
+		c.ForwardOverloadAction = "resume"
+	})
+
+	app.DispatchHTLCAcceptor(ctx)
+
+	// one HTLC is evaluated, one is queued and at least one overflows
+	for i := uint64(0); i < 3; i++ {
+		client.htlcInterceptorRequests <- &routerrpc.ForwardHtlcInterceptRequest{
+			IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: 770495967390531585, HtlcId: 1337000 + i},
+			OutgoingRequestedChanId: 759495353533530113,
+		}
+	}
+
+	resp := <-client.htlcInterceptorResponses
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_RESUME, resp.Action)
+
+	// every HTLC is resolved exactly once
+	resolved := map[uint64]bool{resp.IncomingCircuitKey.HtlcId: true}
+	for len(resolved) < 3 {
+		resp := <-client.htlcInterceptorResponses
+		require.False(t, resolved[resp.IncomingCircuitKey.HtlcId])
+		resolved[resp.IncomingCircuitKey.HtlcId] = true
+	}
+	require.Equal(t, uint64(3), app.overloads.Load()+app.fallbacks.Load())
+	require.GreaterOrEqual(t, app.overloads.Load(), uint64(1))
+}
+
+// monitor mode resumes HTLCs that overflow the queue and only records the
+// overload
+func TestHTLCOverload_Monitor(t *testing.T) {
+	client := newLndclientMock()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := NewApp(ctx, client)
+
+	useHtlcForwardRule(t, "while (true) {}")
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "monitor"
+		c.ForwardMonitorMode = "denylist"
+		c.ForwardDenylist = []string{}
+		c.ApiRules.Apply = true
+		c.ForwardFallbackAction = "fail"
+		c.ForwardEvaluationTimeout = 1
+		c.ForwardWorkers = 1
+		c.ForwardQueueSize = 1
+		c.ForwardOverloadAction = "fail"
+	})
+
+	app.DispatchHTLCAcceptor(ctx)
+
+	for i := uint64(0); i < 3; i++ {
+		client.htlcInterceptorRequests <- &routerrpc.ForwardHtlcInterceptRequest{
+			IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: 770495967390531585, HtlcId: 1337000 + i},
+			OutgoingRequestedChanId: 759495353533530113,
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		resp := <-client.htlcInterceptorResponses
+		require.Equal(t, routerrpc.ResolveHoldForwardAction_RESUME, resp.Action)
+	}
+	require.GreaterOrEqual(t, app.overloads.Load(), uint64(1))
+	app.monitor.mu.Lock()
+	require.Equal(t, int(app.overloads.Load()), app.monitor.forwards["overload"])
+	app.monitor.mu.Unlock()
+}
+
+// a denied HTLC fails with the code of the chain rule that denied it, or the
+// configured default
+func TestHTLCFailureCode_Rule(t *testing.T) {
+	client := newLndclientMock()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := NewApp(ctx, client)
+
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "chain"
+		c.ForwardChain = []string{"deny 700762x1327x1->690757x1005x1 INVALID_ONION_VERSION"}
+		c.ForwardDefault = "deny"
+		c.ForwardFailureCode = "INVALID_ONION_KEY"
+	})
+
+	app.DispatchHTLCAcceptor(ctx)
+
+	client.htlcInterceptorRequests <- &routerrpc.ForwardHtlcInterceptRequest{
+		IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: 770495967390531585, HtlcId: 1337000},
+		OutgoingRequestedChanId: 759495353533530113,
+		OutgoingAmountMsat:      99999999,
+	}
+	resp := <-client.htlcInterceptorResponses
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_FAIL, resp.Action)
+	require.Equal(t, lnrpc.Failure_INVALID_ONION_VERSION, resp.FailureCode)
+
+	client.htlcInterceptorRequests <- &routerrpc.ForwardHtlcInterceptRequest{
+		IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: 759495353533530113, HtlcId: 1337001},
+		OutgoingRequestedChanId: 770495967390531585,
+		OutgoingAmountMsat:      99999999,
+	}
+	resp = <-client.htlcInterceptorResponses
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_FAIL, resp.Action)
+	require.Equal(t, lnrpc.Failure_INVALID_ONION_KEY, resp.FailureCode)
+}
+
+// a chain can mix rules on peers and rules on channels
+func TestHTLCChain_Peers(t *testing.T) {
+	client := newLndclientMock()
+	peer := "03006fcf3312dae8d068ea297f58e2bd00ec1ffe214b793eda46966b6294a53ce6"
+	client.peers = map[uint64]string{770495967390531585: peer}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := NewApp(ctx, client)
+
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "chain"
+		c.ForwardChain = []string{"deny " + peer, "allow *->25328x256x0"}
+		c.ForwardDefault = "deny"
+		c.ApiRules.Apply = false
+	})
+
+	app.DispatchHTLCAcceptor(ctx)
+
+	for _, tc := range []struct {
+		in, out uint64
+		action  routerrpc.ResolveHoldForwardAction
+	}{
+		// from the denied peer
+		{770495967390531585, 27848430525087744, routerrpc.ResolveHoldForwardAction_FAIL},
+		// from another peer to the allowed channel
+		{759495353533530113, 27848430525087744, routerrpc.ResolveHoldForwardAction_RESUME},
+		// the default
+		{759495353533530113, 770495967390531585, routerrpc.ResolveHoldForwardAction_FAIL},
+	} {
+		client.htlcInterceptorRequests <- &routerrpc.ForwardHtlcInterceptRequest{
+			IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: tc.in, HtlcId: 1337000},
+			OutgoingRequestedChanId: tc.out,
+		}
+		resp := <-client.htlcInterceptorResponses
+		require.Equal(t, tc.action, resp.Action)
+	}
+}
+
+// a script denies an HTLC with a failure code by returning its name
+func TestHTLCFailureCode_Script(t *testing.T) {
+	client := newLndclientMock()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := NewApp(ctx, client)
+
+	useHtlcForwardRule(t, `"INVALID_ONION_HMAC"`)
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "denylist"
+		c.ForwardDenylist = []string{}
+		c.ApiRules.Apply = true
+	})
+
+	app.DispatchHTLCAcceptor(ctx)
+
+	client.htlcInterceptorRequests <- &routerrpc.ForwardHtlcInterceptRequest{
+		IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: 770495967390531585, HtlcId: 1337000},
+		OutgoingRequestedChanId: 759495353533530113,
+	}
+	resp := <-client.htlcInterceptorResponses
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_FAIL, resp.Action)
+	require.Equal(t, lnrpc.Failure_INVALID_ONION_HMAC, resp.FailureCode)
+}
+
+// HTLCs over a rate limit fail with the rate limit failure code and the
+// levels are visible to the rules
+func TestHTLCRateLimit_Channel(t *testing.T) {
+	client := newLndclientMock()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := NewApp(ctx, client)
+	now := time.Unix(1700000000, 0)
+	app.limiter.now = func() time.Time { return now }
+
+	useHtlcForwardRule(t, "HtlcForward.RateLimits.length == 1 && HtlcForward.RateLimits[0].Msat == -1")
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "denylist"
+		c.ForwardDenylist = []string{}
+		c.ApiRules.Apply = true
+		c.ForwardRateLimits = []config.RateLimitConfig{{Scope: "channel", Match: "*", HtlcsPerSecond: 1}}
+		c.ForwardRateLimitFailureCode = "INVALID_ONION_KEY"
+	})
+
+	app.DispatchHTLCAcceptor(ctx)
+
+	send := func(htlcId uint64) *routerrpc.ForwardHtlcInterceptResponse {
+		client.htlcInterceptorRequests <- &routerrpc.ForwardHtlcInterceptRequest{
+			IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: 770495967390531585, HtlcId: htlcId},
+			OutgoingRequestedChanId: 759495353533530113,
+			OutgoingAmountMsat:      99999999,
+		}
+		return <-client.htlcInterceptorResponses
+	}
+
+	resp := send(1)
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_RESUME, resp.Action)
+	resp = send(2)
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_FAIL, resp.Action)
+	require.Equal(t, lnrpc.Failure_INVALID_ONION_KEY, resp.FailureCode)
+
+	// the bucket refills
+	now = now.Add(time.Second)
+	resp = send(3)
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_RESUME, resp.Action)
+}
+
+// an outgoing channel takes no more HTLCs than its in-flight cap until one
+// of them is settled
+func TestHTLCInflight_ChannelCap(t *testing.T) {
+	client := newLndclientMock()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := NewApp(ctx, client)
+
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "denylist"
+		c.ForwardDenylist = []string{}
+		c.ForwardInflightLimits = config.InflightLimitsConfig{ChannelHtlcs: "1"}
+		c.ForwardInflightFailureCode = "INVALID_ONION_VERSION"
+	})
+
+	app.DispatchHTLCAcceptor(ctx)
+
+	send := func(htlcId uint64) *routerrpc.ForwardHtlcInterceptResponse {
+		client.htlcInterceptorRequests <- &routerrpc.ForwardHtlcInterceptRequest{
+			IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: 770495967390531585, HtlcId: htlcId},
+			OutgoingRequestedChanId: 759495353533530113,
+			OutgoingAmountMsat:      99999999,
+		}
+		return <-client.htlcInterceptorResponses
+	}
+
+	resp := send(1)
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_RESUME, resp.Action)
+	resp = send(2)
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_FAIL, resp.Action)
+	require.Equal(t, lnrpc.Failure_INVALID_ONION_VERSION, resp.FailureCode)
+
+	client.htlcEvents <- &routerrpc.HtlcEvent{
+		IncomingChannelId: 770495967390531585,
+		IncomingHtlcId:    1,
+		EventType:         routerrpc.HtlcEvent_FORWARD,
+		Event:             &routerrpc.HtlcEvent_SettleEvent{SettleEvent: &routerrpc.SettleEvent{}},
+	}
+	require.Eventually(t, func() bool {
+		_, channel := app.inflight.totals("", 759495353533530113)
+		return channel.htlcs == 0
+	}, time.Second, 10*time.Millisecond)
+
+	resp = send(3)
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_RESUME, resp.Action)
+}
+
+// percentage caps refer to the negotiated slots of the channel
+func TestHTLCInflight_ChannelSlots(t *testing.T) {
+	client := newLndclientMock()
+	client.channels = []*lnrpc.Channel{
+		{ChanId: 759495353533530113, Capacity: 1000000, LocalBalance: 900000, Active: true,
+			LocalConstraints: &lnrpc.ChannelConstraints{MaxAcceptedHtlcs: 4}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := NewApp(ctx, client)
+	require.NoError(t, app.refreshChannels(ctx))
+
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "denylist"
+		c.ForwardDenylist = []string{}
+		c.ApiRules.Apply = false
+		c.ForwardInflightLimits = config.InflightLimitsConfig{ChannelHtlcs: "50%"}
+	})
+	// channels that are not in the snapshot have 483 slots
+	require.Equal(t, inflightCaps{channelHtlcs: 241}, app.inflightCaps(config.Current().InflightLimits, 770495967390531585, 27848430525087744))
+
+	app.DispatchHTLCAcceptor(ctx)
+
+	for i := uint64(1); i <= 3; i++ {
+		client.htlcInterceptorRequests <- &routerrpc.ForwardHtlcInterceptRequest{
+			IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: 770495967390531585, HtlcId: i},
+			OutgoingRequestedChanId: 759495353533530113,
+			OutgoingAmountMsat:      1000,
+		}
+		resp := <-client.htlcInterceptorResponses
+		if i <= 2 {
+			require.Equal(t, routerrpc.ResolveHoldForwardAction_RESUME, resp.Action)
+		} else {
+			require.Equal(t, routerrpc.ResolveHoldForwardAction_FAIL, resp.Action)
+		}
+	}
+}
+
+func TestInflightTracker_PeerCap(t *testing.T) {
+	tracker := newInflightTracker()
+	limits := inflightCaps{peerHtlcs: 2, peerMsat: 3000}
+
+	_, ok := tracker.reserve(limits, circuitKey{1, 1}, inflightHtlc{peer: "a", outChan: 10, amtMsat: 1000})
+	require.True(t, ok)
+	reason, ok := tracker.reserve(limits, circuitKey{1, 2}, inflightHtlc{peer: "a", outChan: 11, amtMsat: 2500})
+	require.False(t, ok)
+	require.Equal(t, "in-flight cap: 3000 msat from peer", reason)
+	_, ok = tracker.reserve(limits, circuitKey{1, 3}, inflightHtlc{peer: "a", outChan: 11, amtMsat: 1000})
+	require.True(t, ok)
+	reason, ok = tracker.reserve(limits, circuitKey{1, 4}, inflightHtlc{peer: "a", outChan: 12, amtMsat: 1})
+	require.False(t, ok)
+	require.Equal(t, "in-flight cap: 2 HTLCs from peer", reason)
+
+	// other peers have their own caps
+	_, ok = tracker.reserve(limits, circuitKey{2, 1}, inflightHtlc{peer: "b", outChan: 12, amtMsat: 1000})
+	require.True(t, ok)
+
+	_, ok = tracker.resolve(circuitKey{1, 1})
+	require.True(t, ok)
+	_, ok = tracker.resolve(circuitKey{1, 1})
+	require.False(t, ok)
+	peer, channel := tracker.totals("a", 10)
+	require.Equal(t, inflightTotals{htlcs: 1, msat: 1000}, peer)
+	require.Equal(t, inflightTotals{}, channel)
+}
+
+// HTLCs without a settle or fail event free their slot once they expired
+func TestInflightTracker_Expire(t *testing.T) {
+	tracker := newInflightTracker()
+	limits := inflightCaps{peerHtlcs: 1}
+
+	_, ok := tracker.reserve(limits, circuitKey{1, 1}, inflightHtlc{peer: "a", outChan: 10, amtMsat: 1000, expiry: 800040})
+	require.True(t, ok)
+	_, ok = tracker.reserve(limits, circuitKey{1, 2}, inflightHtlc{peer: "a", outChan: 10, amtMsat: 1000, expiry: 800080})
+	require.False(t, ok)
+	tracker.add(circuitKey{2, 1}, inflightHtlc{peer: "b", outChan: 10, amtMsat: 1000, expiry: 800100})
+
+	require.Zero(t, tracker.expire(800040))
+	require.Equal(t, 1, tracker.expire(800041))
+	_, ok = tracker.reserve(limits, circuitKey{1, 2}, inflightHtlc{peer: "a", outChan: 10, amtMsat: 1000, expiry: 800080})
+	require.True(t, ok)
+	_, channel := tracker.totals("a", 10)
+	require.Equal(t, inflightTotals{htlcs: 2, msat: 2000}, channel)
+}
+
+// a peer with a low reputation is only forwarded while the outgoing channel
+// is not busy
+func TestHTLCReputation_LowScore(t *testing.T) {
+	client := newLndclientMock()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := NewApp(ctx, client)
+
+	// a peer without history scores 0.5
+	useHtlcForwardRule(t, "HtlcForward.Reputation.Score == 0.5")
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "denylist"
+		c.ForwardDenylist = []string{}
+		c.ApiRules.Apply = true
+		c.ForwardReputation.MinScore = 0.6
+		c.ForwardReputation.MaxChannelHtlcs = "1"
+		c.ForwardReputation.FailureCode = "INVALID_ONION_HMAC"
+	})
+
+	app.DispatchHTLCAcceptor(ctx)
+
+	send := func(htlcId uint64) *routerrpc.ForwardHtlcInterceptResponse {
+		client.htlcInterceptorRequests <- &routerrpc.ForwardHtlcInterceptRequest{
+			IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: 770495967390531585, HtlcId: htlcId},
+			OutgoingRequestedChanId: 759495353533530113,
+			OutgoingAmountMsat:      99999999,
+		}
+		return <-client.htlcInterceptorResponses
+	}
+
+	resp := send(1)
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_RESUME, resp.Action)
+	resp = send(2)
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_FAIL, resp.Action)
+	require.Equal(t, lnrpc.Failure_INVALID_ONION_HMAC, resp.FailureCode)
+}
+
+// a peer with a low reputation is only forwarded to channels that keep
+// plenty of local balance
+func TestHTLCReputation_Depleted(t *testing.T) {
+	client := newLndclientMock()
+	client.channels = []*lnrpc.Channel{
+		{ChanId: 759495353533530113, Capacity: 1000000, LocalBalance: 400000, Active: true},
+		{ChanId: 27848430525087744, Capacity: 1000000, LocalBalance: 900000, Active: true},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := NewApp(ctx, client)
+	require.NoError(t, app.refreshChannels(ctx))
+
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "denylist"
+		c.ForwardDenylist = []string{}
+		c.ApiRules.Apply = false
+		c.ForwardReputation.MinScore = 0.6
+		c.ForwardReputation.MinLocalBalance = ""
+		c.ForwardReputation.FailureCode = "INVALID_ONION_HMAC"
+	})
+	require.Equal(t, config.Floor{Percent: 50}, config.Current().ReputationMinLocalBalance)
+
+	app.DispatchHTLCAcceptor(ctx)
+
+	send := func(htlcId, out uint64) *routerrpc.ForwardHtlcInterceptResponse {
+		client.htlcInterceptorRequests <- &routerrpc.ForwardHtlcInterceptRequest{
+			IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: 770495967390531585, HtlcId: htlcId},
+			OutgoingRequestedChanId: out,
+			OutgoingAmountMsat:      150000000,
+		}
+		return <-client.htlcInterceptorResponses
+	}
+
+	// 400k sat - 150k sat is below half of the capacity
+	resp := send(1, 759495353533530113)
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_FAIL, resp.Action)
+	require.Equal(t, lnrpc.Failure_INVALID_ONION_HMAC, resp.FailureCode)
+	_, channel := app.inflight.totals("", 759495353533530113)
+	require.Zero(t, channel.htlcs)
+
+	resp = send(2, 27848430525087744)
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_RESUME, resp.Action)
+}
+
+func TestReputationStore(t *testing.T) {
+	conf := config.Current().Copy()
+	conf.ForwardReputation.HalfLifeHours = 1
+	conf.ForwardReputation.SlowHoldSeconds = 30
+
+	store := newReputationStore()
+	now := time.Unix(1700000000, 0)
+	store.now = func() time.Time { return now }
+
+	store.record(conf, "peer", outcomeSettled, time.Second)
+	store.record(conf, "peer", outcomeSettled, time.Minute)
+	store.record(conf, "peer", outcomeLinkFailed, time.Second)
+	rep := store.get(conf, "peer")
+	// (2 + 1) / (2 + 1 slow + 2 link failures + 2)
+	require.InDelta(t, 3.0/7, rep.Score, 1e-9)
+	require.InDelta(t, 62.0/3, rep.AvgHoldSeconds, 1e-9)
+	require.Equal(t, 0.5, store.get(conf, "unknown").Score)
+
+	// outcomes count half after the half-life
+	now = now.Add(time.Hour)
+	rep = store.get(conf, "peer")
+	require.InDelta(t, 1.0, rep.Settled, 1e-9)
+	require.InDelta(t, 0.5, rep.LinkFailures, 1e-9)
+
+	path := filepath.Join(t.TempDir(), "reputation.json")
+	require.NoError(t, store.save(path))
+	loaded := newReputationStore()
+	loaded.now = store.now
+	require.NoError(t, loaded.load(path))
+	require.Equal(t, rep, loaded.get(conf, "peer"))
+}
+
+// forwards that pay less than the minimum fee or fee rate fail, with
+// overrides for an outgoing channel
+func TestHTLCFeePolicy(t *testing.T) {
+	client := newLndclientMock()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := NewApp(ctx, client)
+
+	// the rules see the fee
+	useHtlcForwardRule(t, "HtlcForward.FeeMsat >= 0")
+	zero := uint64(0)
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "denylist"
+		c.ForwardDenylist = []string{}
+		c.ApiRules.Apply = true
+		c.ForwardFeePolicy = config.FeePolicyConfig{
+			MinFeeMsat: 1000,
+			MinFeePpm:  100,
+			Channels: []config.FeeOverrideConfig{
+				{Channel: "700762x1327x1", MinFeeMsat: &zero},
+			},
+			FailureCode: "INVALID_ONION_VERSION",
+		}
+	})
+
+	app.DispatchHTLCAcceptor(ctx)
+
+	send := func(out uint64, incoming, outgoing uint64) *routerrpc.ForwardHtlcInterceptResponse {
+		client.htlcInterceptorRequests <- &routerrpc.ForwardHtlcInterceptRequest{
+			IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: 759495353533530113, HtlcId: 1337000},
+			OutgoingRequestedChanId: out,
+			IncomingAmountMsat:      incoming,
+			OutgoingAmountMsat:      outgoing,
+		}
+		return <-client.htlcInterceptorResponses
+	}
+
+	// 2000 msat, 200 ppm
+	resp := send(1, 10_002_000, 10_000_000)
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_RESUME, resp.Action)
+	// 500 msat
+	resp = send(1, 1_000_500, 1_000_000)
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_FAIL, resp.Action)
+	require.Equal(t, lnrpc.Failure_INVALID_ONION_VERSION, resp.FailureCode)
+	// 1000 msat but only 10 ppm
+	resp = send(1, 100_001_000, 100_000_000)
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_FAIL, resp.Action)
+
+	// no minimum fee to 700762x1327x1, but the global fee rate
+	resp = send(770495967390531585, 1_000_500, 1_000_000)
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_RESUME, resp.Action)
+	resp = send(770495967390531585, 100_000_500, 100_000_000)
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_FAIL, resp.Action)
+}
+
+// forwards with a short CLTV delta or a far away expiry fail, with overrides
+// for an outgoing channel
+func TestHTLCCltvPolicy(t *testing.T) {
+	client := newLndclientMock()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := NewApp(ctx, client)
+
+	// the mock is at block 800000
+	useHtlcForwardRule(t, "HtlcForward.BlocksUntilOutgoingExpiry == HtlcForward.Event.OutgoingExpiry - 800000")
+	minDelta := uint32(144)
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "denylist"
+		c.ForwardDenylist = []string{}
+		c.ApiRules.Apply = true
+		c.ForwardCltvPolicy = config.CltvPolicyConfig{
+			MinDelta:        40,
+			MaxExpiryBlocks: 2016,
+			Channels: []config.CltvOverrideConfig{
+				{Channel: "700762x1327x1", MinDelta: &minDelta},
+			},
+			FailureCode: "INVALID_ONION_KEY",
+		}
+	})
+
+	app.DispatchHTLCAcceptor(ctx)
+
+	send := func(out uint64, incomingExpiry, outgoingExpiry uint32) *routerrpc.ForwardHtlcInterceptResponse {
+		client.htlcInterceptorRequests <- &routerrpc.ForwardHtlcInterceptRequest{
+			IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: 759495353533530113, HtlcId: 1337000},
+			OutgoingRequestedChanId: out,
+			IncomingExpiry:          incomingExpiry,
+			OutgoingExpiry:          outgoingExpiry,
+		}
+		return <-client.htlcInterceptorResponses
+	}
+
+	resp := send(1, 800140, 800100)
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_RESUME, resp.Action)
+	resp = send(1, 800139, 800100)
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_FAIL, resp.Action)
+	require.Equal(t, lnrpc.Failure_INVALID_ONION_KEY, resp.FailureCode)
+	// lock-up
+	resp = send(1, 805000, 804000)
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_FAIL, resp.Action)
+
+	// a larger delta to 700762x1327x1
+	resp = send(770495967390531585, 800140, 800100)
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_FAIL, resp.Action)
+	resp = send(770495967390531585, 800244, 800100)
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_RESUME, resp.Action)
+}
+
+// the rules see snapshots of the channels of a forward
+func TestHTLCChannelSnapshot(t *testing.T) {
+	client := newLndclientMock()
+	client.channels = []*lnrpc.Channel{
+		{ChanId: 759495353533530113, Capacity: 1000000, LocalBalance: 400000, RemoteBalance: 590000, Active: true},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := NewApp(ctx, client)
+	require.NoError(t, app.refreshChannels(ctx))
+
+	useHtlcForwardRule(t, "HtlcForward.IncomingChannel == null && HtlcForward.OutgoingChannel.LocalBalance / HtlcForward.OutgoingChannel.Capacity >= 0.4")
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "denylist"
+		c.ForwardDenylist = []string{}
+		c.ApiRules.Apply = true
+	})
+
+	app.DispatchHTLCAcceptor(ctx)
+
+	client.htlcInterceptorRequests <- &routerrpc.ForwardHtlcInterceptRequest{
+		IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: 770495967390531585, HtlcId: 1337000},
+		OutgoingRequestedChanId: 759495353533530113,
+	}
+	resp := <-client.htlcInterceptorResponses
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_RESUME, resp.Action)
+
+	// the channel was drained
+	client.channels[0].LocalBalance = 100000
+	require.NoError(t, app.refreshChannels(ctx))
+	client.htlcInterceptorRequests <- &routerrpc.ForwardHtlcInterceptRequest{
+		IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: 770495967390531585, HtlcId: 1337001},
+		OutgoingRequestedChanId: 759495353533530113,
+	}
+	resp = <-client.htlcInterceptorResponses
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_FAIL, resp.Action)
+}
+
+func TestHTLCLiquidityFloor(t *testing.T) {
+	peer := "03006fcf3312dae8d068ea297f58e2bd00ec1ffe214b793eda46966b6294a53ce6"
+	client := newLndclientMock()
+	client.channels = []*lnrpc.Channel{
+		{ChanId: 759495353533530113, RemotePubkey: peer, Capacity: 1000000, LocalBalance: 400000, Active: true},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := NewApp(ctx, client)
+	require.NoError(t, app.refreshChannels(ctx))
+
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "denylist"
+		c.ForwardDenylist = []string{}
+		c.ForwardLiquidityPolicy = config.LiquidityPolicyConfig{
+			MinLocalBalance: "10%",
+			Channels:        []config.LiquidityFloorConfig{{Peer: peer, MinLocalBalance: "200000"}},
+			FailureCode:     "INVALID_ONION_KEY",
+		}
+	})
+
+	app.DispatchHTLCAcceptor(ctx)
+
+	send := func(htlcId uint64) *routerrpc.ForwardHtlcInterceptResponse {
+		client.htlcInterceptorRequests <- &routerrpc.ForwardHtlcInterceptRequest{
+			IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: 770495967390531585, HtlcId: htlcId},
+			OutgoingRequestedChanId: 759495353533530113,
+			OutgoingAmountMsat:      150000000,
+		}
+		return <-client.htlcInterceptorResponses
+	}
+
+	// 400k sat - 150k sat stays above the peer floor of 200k sat
+	resp := send(1)
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_RESUME, resp.Action)
+	// the first HTLC is still in flight and not in the snapshot
+	resp = send(2)
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_FAIL, resp.Action)
+	require.Equal(t, lnrpc.Failure_INVALID_ONION_KEY, resp.FailureCode)
+
+	client.htlcEvents <- &routerrpc.HtlcEvent{
+		IncomingChannelId: 770495967390531585,
+		IncomingHtlcId:    1,
+		EventType:         routerrpc.HtlcEvent_FORWARD,
+		Event:             &routerrpc.HtlcEvent_ForwardFailEvent{ForwardFailEvent: &routerrpc.ForwardFailEvent{}},
+	}
+	require.Eventually(t, func() bool {
+		_, channel := app.inflight.totals("", 759495353533530113)
+		return channel.htlcs == 0
+	}, time.Second, 10*time.Millisecond)
+
+	resp = send(3)
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_RESUME, resp.Action)
+}
+
+func TestHTLCFlowPolicy_SamePeer(t *testing.T) {
+	client := newLndclientMock()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := NewApp(ctx, client)
+
+	// both channels of the mock are with the same peer
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "denylist"
+		c.ForwardDenylist = []string{}
+		c.ForwardFlowPolicy = config.FlowPolicyConfig{
+			SamePeer:    config.FlowActionConfig{Action: "min-fee", MinFeePpm: 1000},
+			FailureCode: "INVALID_ONION_KEY",
+		}
+	})
+
+	app.DispatchHTLCAcceptor(ctx)
+
+	send := func(htlcId uint64, incoming, outgoing uint64) *routerrpc.ForwardHtlcInterceptResponse {
+		client.htlcInterceptorRequests <- &routerrpc.ForwardHtlcInterceptRequest{
+			IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: 770495967390531585, HtlcId: htlcId},
+			OutgoingRequestedChanId: 759495353533530113,
+			IncomingAmountMsat:      incoming,
+			OutgoingAmountMsat:      outgoing,
+		}
+		return <-client.htlcInterceptorResponses
+	}
+
+	// 2000 ppm
+	resp := send(1, 1_002_000, 1_000_000)
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_RESUME, resp.Action)
+	// 500 ppm
+	resp = send(2, 1_000_500, 1_000_000)
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_FAIL, resp.Action)
+	require.Equal(t, lnrpc.Failure_INVALID_ONION_KEY, resp.FailureCode)
+}
+
+func TestCheckFlow_Groups(t *testing.T) {
+	setConfig(t, func(c *config.Config) {
+		c.ForwardFlowPolicy = config.FlowPolicyConfig{
+			Groups: map[string][]string{
+				"sources": {"700762x1327x1"},
+				"sinks":   {"690757x1005x1", "691000x100x0"},
+			},
+			BetweenGroups: []config.GroupFlowConfig{{From: "sources", To: "sinks", Action: "deny"}},
+		}
+	})
+	conf := config.Current()
+	source, _ := config.ParseShortChannelID("700762x1327x1")
+	sink, _ := config.ParseShortChannelID("691000x100x0")
+
+	fwd := func(in, out uint64) types.HtlcForwardEvent {
+		return types.HtlcForwardEvent{
+			PubkeyFrom: "peer-a",
+			PubkeyTo:   "peer-b",
+			Event: &routerrpc.ForwardHtlcInterceptRequest{
+				IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: in},
+				OutgoingRequestedChanId: out,
+			},
+		}
+	}
+
+	reason, ok := checkFlow(conf, fwd(source, sink))
+	require.False(t, ok)
+	require.Equal(t, "flow policy: group sources->sinks forward denied", reason)
+	_, ok = checkFlow(conf, fwd(sink, source))
+	require.True(t, ok)
+}
+
+func TestHTLCCustomRecords(t *testing.T) {
+	client := newLndclientMock()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := NewApp(ctx, client)
+
+	useHtlcForwardRule(t, "!HtlcForward.CustomRecords.Boostagram || HtlcForward.CustomRecords.Boostagram.app_name != 'spam'")
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "denylist"
+		c.ForwardDenylist = []string{}
+		c.ApiRules.Apply = true
+		c.ForwardCustomRecords = config.CustomRecordsConfig{
+			Deny:          []uint64{5482373484},
+			MaxRecordSize: 100,
+			FailureCode:   "INVALID_ONION_KEY",
+		}
+	})
+
+	app.DispatchHTLCAcceptor(ctx)
+
+	send := func(htlcId uint64, records map[uint64][]byte) *routerrpc.ForwardHtlcInterceptResponse {
+		client.htlcInterceptorRequests <- &routerrpc.ForwardHtlcInterceptRequest{
+			IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: 770495967390531585, HtlcId: htlcId},
+			OutgoingRequestedChanId: 759495353533530113,
+			CustomRecords:           records,
+		}
+		return <-client.htlcInterceptorResponses
+	}
+
+	resp := send(1, map[uint64][]byte{7629169: []byte(`{"app_name":"podcaster"}`)})
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_RESUME, resp.Action)
+	resp = send(2, map[uint64][]byte{7629169: []byte(`{"app_name":"spam"}`)})
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_FAIL, resp.Action)
+	// denied type
+	resp = send(3, map[uint64][]byte{5482373484: make([]byte, 32)})
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_FAIL, resp.Action)
+	require.Equal(t, lnrpc.Failure_INVALID_ONION_KEY, resp.FailureCode)
+	// oversized record
+	resp = send(4, map[uint64][]byte{65536: make([]byte, 101)})
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_FAIL, resp.Action)
+	require.Equal(t, lnrpc.Failure_INVALID_ONION_KEY, resp.FailureCode)
+}
+
+func TestDecodeCustomRecords(t *testing.T) {
+	pubkey := make([]byte, 33)
+	pubkey[0] = 0x02
+	records := decodeCustomRecords(map[uint64][]byte{
+		5482373484: make([]byte, 32),
+		34349339:   pubkey,
+		34349334:   []byte("hello"),
+		7629171:    {0xff},
+		65537:      {0xab, 0xcd},
+	})
+	require.True(t, records.KeysendPreimage)
+	require.Equal(t, "02"+strings.Repeat("00", 32), records.SenderPubkey)
+	require.Equal(t, "hello", records.Message)
+	// not valid UTF-8
+	require.Equal(t, "", records.TipNote)
+	require.Nil(t, records.Boostagram)
+	require.Equal(t, "abcd", records.Records["65537"])
+	require.Equal(t, 32+33+5+1+2, records.Size)
+}
+
+func TestHTLCLifecycle(t *testing.T) {
+	client := newLndclientMock()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := NewApp(ctx, client)
+	completed := make(chan types.ForwardLifecycle, 10)
+	app.SubscribeLifecycles(func(l types.ForwardLifecycle) {
+		completed <- l
+	})
+	hook := logtest.NewGlobal()
+	defer log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+	// lifecycle returns the fields of the next logged lifecycle
+	seen := 0
+	lifecycle := func() log.Fields {
+		for {
+			for _, entry := range hook.AllEntries()[seen:] {
+				seen++
+				if entry.Data["event"] == "forward_lifecycle" {
+					return entry.Data
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	setConfig(t, func(c *config.Config) {
+		c.ForwardMode = "denylist"
+		c.ForwardDenylist = []string{"700762x1327x1"}
+		c.LogJson = true
+	})
+
+	app.DispatchHTLCAcceptor(ctx)
+
+	client.htlcInterceptorRequests <- &routerrpc.ForwardHtlcInterceptRequest{
+		IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: 759495353533530113, HtlcId: 1},
+		OutgoingRequestedChanId: 770495967390531585,
+		IncomingAmountMsat:      1_001_000,
+		OutgoingAmountMsat:      1_000_000,
+	}
+	resp := <-client.htlcInterceptorResponses
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_RESUME, resp.Action)
+
+	client.htlcEvents <- &routerrpc.HtlcEvent{
+		IncomingChannelId: 759495353533530113,
+		IncomingHtlcId:    1,
+		EventType:         routerrpc.HtlcEvent_FORWARD,
+		Event: &routerrpc.HtlcEvent_LinkFailEvent{LinkFailEvent: &routerrpc.LinkFailEvent{
+			FailureString: "insufficient bandwidth",
+		}},
+	}
+	l := lifecycle()
+	require.Equal(t, "allow", l["decision"])
+	require.Equal(t, resolutionLinkFail, l["resolution"])
+	require.Equal(t, "insufficient bandwidth", l["failure_string"])
+	require.Equal(t, ParseChannelID(770495967390531585), l["out_chan_id"])
+	require.Equal(t, int64(1000), l["fee_msat"])
+	require.Equal(t, int64(0), l["earned_msat"])
+	subscribed := <-completed
+	require.Equal(t, resolutionLinkFail, subscribed.Resolution)
+	require.Equal(t, uint64(1), subscribed.IncomingHtlcId)
+
+	// a denied HTLC completes right away
+	client.htlcInterceptorRequests <- &routerrpc.ForwardHtlcInterceptRequest{
+		IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: 770495967390531585, HtlcId: 2},
+		OutgoingRequestedChanId: 759495353533530113,
+	}
+	resp = <-client.htlcInterceptorResponses
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_FAIL, resp.Action)
+	l = lifecycle()
+	require.Equal(t, "deny", l["decision"])
+	require.Equal(t, resolutionFail, l["resolution"])
+	require.Equal(t, uint64(2), l["htlc_id"])
+	subscribed = <-completed
+	require.Equal(t, resolutionFail, subscribed.Resolution)
+	require.Equal(t, "deny", subscribed.Decision)
+
+	// a settled HTLC earns its fee
+	client.htlcInterceptorRequests <- &routerrpc.ForwardHtlcInterceptRequest{
+		IncomingCircuitKey:      &routerrpc.CircuitKey{ChanId: 759495353533530113, HtlcId: 4},
+		OutgoingRequestedChanId: 770495967390531585,
+		IncomingAmountMsat:      1_001_000,
+		OutgoingAmountMsat:      1_000_000,
+	}
+	resp = <-client.htlcInterceptorResponses
+	require.Equal(t, routerrpc.ResolveHoldForwardAction_RESUME, resp.Action)
+	client.htlcEvents <- &routerrpc.HtlcEvent{
+		IncomingChannelId: 759495353533530113,
+		IncomingHtlcId:    4,
+		EventType:         routerrpc.HtlcEvent_FORWARD,
+		Event:             &routerrpc.HtlcEvent_SettleEvent{SettleEvent: &routerrpc.SettleEvent{}},
+	}
+	subscribed = <-completed
+	require.Equal(t, resolutionSettle, subscribed.Resolution)
+	require.Equal(t, int64(1000), subscribed.EarnedFeeMsat)
+
+	// a lifecycle without a settle or fail event is dropped once the HTLC
+	// expired
+	app.lifecycles.intercepted(circuitKey{759495353533530113, 3}, types.ForwardLifecycle{}, 800040, true)
+	require.Zero(t, app.lifecycles.expire(800040))
+	require.Equal(t, 1, app.lifecycles.expire(800041))
+	subscribed = <-completed
+	require.Equal(t, resolutionExpired, subscribed.Resolution)
+}
+
+func TestRateLimiter_PeerMsat(t *testing.T) {
+	peer := "02853f9c1d15d479b433039885373b681683b84bb73e86dff861bee6697c17c1de"
+	other := "03006fcf3312dae8d068ea297f58e2bd00ec1ffe214b793eda46966b6294a53ce6"
+	conf := &config.Config{}
+	l, err := config.ParseRateLimit(config.RateLimitConfig{Scope: "peer", Match: peer, MsatPerMinute: 60000})
+	require.NoError(t, err)
+	conf.RateLimits = []config.RateLimit{l}
+
+	limiter := newRateLimiter()
+	now := time.Unix(1700000000, 0)
+	limiter.now = func() time.Time { return now }
+
+	_, ok := limiter.take(conf, 1, 2, peer, 50000)
+	require.True(t, ok)
+	reason, ok := limiter.take(conf, 1, 2, peer, 20000)
+	require.False(t, ok)
+	require.Equal(t, "rate limit 1: peer "+peer+": 60000 msat/min", reason)
+
+	// other peers are not limited
+	_, ok = limiter.take(conf, 1, 2, other, 20000)
+	require.True(t, ok)
+
+	// 1000 msat per second are refilled
+	now = now.Add(10 * time.Second)
+	levels := limiter.levels(conf, 1, 2, peer)
+	require.Len(t, levels, 1)
+	require.Equal(t, float64(20000), levels[0].Msat)
+	require.Equal(t, float64(-1), levels[0].Htlcs)
+	_, ok = limiter.take(conf, 1, 2, peer, 20000)
+	require.True(t, ok)
+}
+
+func TestCombineDecisions(t *testing.T) {
+	tests := []struct {
+		policy string
+		list   bool
+		result rules.Result
+		accept bool
+	}{
+		{config.PolicyAll, true, rules.Allow, true},
+		{config.PolicyAll, true, rules.Deny, false},
+		{config.PolicyAll, true, rules.NoDecision, true},
+		{config.PolicyAll, false, rules.Allow, false},
+		{config.PolicyAny, false, rules.Allow, true},
+		{config.PolicyAny, false, rules.NoDecision, false},
+		{config.PolicyListOnly, true, rules.Deny, true},
+		{config.PolicyRulesOnly, false, rules.Allow, true},
+		{config.PolicyRulesOnly, true, rules.Deny, false},
+		// a list-allowed peer can be vetoed by the rules
+		{config.PolicyRulesOverride, true, rules.Deny, false},
+		// a peer missing from the allowlist can be admitted by the rules
+		{config.PolicyRulesOverride, false, rules.Allow, true},
+		{config.PolicyRulesOverride, false, rules.NoDecision, false},
+	}
+	for _, test := range tests {
+		require.Equal(t, test.accept, combineDecisions(test.policy, test.list, test.result),
+			"policy %s, list %t, rules %s", test.policy, test.list, test.result)
+	}
+}
+
+// --------------- Channel accept tests ---------------
+
+func TestChannelAllowlist_CorrectKey(t *testing.T) {
+	client := newLndclientMock()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := NewApp(ctx, client)
+	pubkey_str := "03006fcf3312dae8d068ea297f58e2bd00ec1ffe214b793eda46966b6294a53ce6"
+	setConfig(t, func(c *config.Config) {
+		c.ChannelMode = "allowlist"
+		c.ChannelAllowlist = []string{pubkey_str}
+	})
+
+	app.DispatchChannelAcceptor(ctx)
+
+	// correct key: should be allowed
+	pubkey, _ := hex.DecodeString(pubkey_str)
+	client.channelAcceptorRequests <- &lnrpc.ChannelAcceptRequest{
+		NodePubkey:    pubkey,
+		FundingAmt:    1337000,
+		PendingChanId: []byte("759495353533530113"),
+	}
+
+	resp := <-client.channelAcceptorResponses
+	require.Equal(t, resp.Accept, true)
+
+}
+func TestChannelAllowlist_WrongKey(t *testing.T) {
+	client := newLndclientMock()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := NewApp(ctx, client)
+
+	pubkey_str := "03006fcf3312dae8d068ea297f58e2bd00ec1ffe214b793eda46966b6294a53ce6"
+	setConfig(t, func(c *config.Config) {
+		c.ChannelMode = "allowlist"
+		c.ChannelAllowlist = []string{pubkey_str}
+	})
+
+	app.DispatchChannelAcceptor(ctx)
+	// wrong key: should be denied
+
+	client.channelAcceptorRequests <- &lnrpc.ChannelAcceptRequest{
+		NodePubkey:    []byte("WRONG-KEY"),
+		FundingAmt:    1337000,
+		PendingChanId: []byte("759495353533530113"),
+	}
+
+	resp := <-client.channelAcceptorResponses
+	require.Equal(t, resp.Accept, false)
+}
+func TestChannelAllowlist_Wildcard(t *testing.T) {
+	client := newLndclientMock()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := NewApp(ctx, client)
+
+	app.DispatchChannelAcceptor(ctx)
+
+	pubkey_str := "03006fcf3312dae8d068ea297f58e2bd00ec1ffe214b793eda46966b6294a53ce6"
+	// wildcard: should be allowed
+	setConfig(t, func(c *config.Config) {
+		c.ChannelMode = "allowlist"
+		c.ChannelAllowlist = []string{"*"}
+	})
+
+	pubkey, _ := hex.DecodeString(pubkey_str)
+	client.channelAcceptorRequests <- &lnrpc.ChannelAcceptRequest{
+		NodePubkey:    pubkey,
+		FundingAmt:    1337000,
+		PendingChanId: []byte("759495353533530113"),
+	}
+
+	resp := <-client.channelAcceptorResponses
+	require.Equal(t, resp.Accept, true)
+}
+
+func TestChannelDenylist_Match(t *testing.T) {
+	client := newLndclientMock()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := NewApp(ctx, client)
+	pubkey_str := "03006fcf3312dae8d068ea297f58e2bd00ec1ffe214b793eda46966b6294a53ce6"
+	setConfig(t, func(c *config.Config) {
+		c.ChannelMode = "denylist"
+		c.ChannelDenylist = []string{pubkey_str}
+	})
+
+	app.DispatchChannelAcceptor(ctx)
+
+	// should be denied
+	pubkey, _ := hex.DecodeString(pubkey_str)
+	client.channelAcceptorRequests <- &lnrpc.ChannelAcceptRequest{
+		NodePubkey:    []byte(pubkey),
+		FundingAmt:    1337000,
+		PendingChanId: []byte("759495353533530113"),
+	}
+
+	resp := <-client.channelAcceptorResponses
+	require.Equal(t, resp.Accept, false)
+}
+
+func TestChannelAllowlist_Match(t *testing.T) {
+	client := newLndclientMock()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := NewApp(ctx, client)
+	pubkey_str := "03006fcf3312dae8d068ea297f58e2bd00ec1ffe214b793eda46966b6294a53ce6"
+	setConfig(t, func(c *config.Config) {
+		c.ChannelMode = "allowlist"
+		c.ChannelAllowlist = []string{pubkey_str}
+	})
+
+	app.DispatchChannelAcceptor(ctx)
+
+	// should be allowed
+	pubkey, _ := hex.DecodeString(pubkey_str)
+	client.channelAcceptorRequests <- &lnrpc.ChannelAcceptRequest{
+		NodePubkey:    []byte(pubkey),
+		FundingAmt:    1337000,
+		PendingChanId: []byte("759495353533530113"),
+	}
+
+	resp := <-client.channelAcceptorResponses
+	require.Equal(t, resp.Accept, true)
+}
+
+// --------------- Config reload tests ---------------
+
+func writeTestConfig(t *testing.T, forwardMode string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := fmt.Sprintf("host: \"127.0.0.1:10009\"\nmacaroon_path: \"admin.macaroon\"\ntls-path: \"tls.cert\"\nforward-mode: %q\n", forwardMode)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+// a valid config file replaces the active configuration
+func TestConfigReload_Valid(t *testing.T) {
+	previous, previousPath := config.Current(), config.ConfigPath
+	defer func() {
+		config.ConfigPath = previousPath
+		require.NoError(t, config.Set(previous))
+	}()
+
+	config.ConfigPath = writeTestConfig(t, "allowlist")
+	require.NoError(t, config.Reload())
+	require.Equal(t, "allowlist", config.Current().ForwardMode)
+}
+
+// an invalid config file keeps the previous configuration active
+func TestConfigReload_Invalid(t *testing.T) {
+	previous, previousPath := config.Current(), config.ConfigPath
+	defer func() {
+		config.ConfigPath = previousPath
+		require.NoError(t, config.Set(previous))
+	}()
+
+	config.ConfigPath = writeTestConfig(t, "sometimes")
+	require.Error(t, config.Reload())
+	require.Same(t, previous, config.Current())
+}
+
+// --------------- Rules tests ---------------
+
+// rule scripts are compiled once and recompiled on Load
+func TestRules_Recompile(t *testing.T) {
+	previousDir := rules.Dir
+	defer func() {
+		rules.Dir = previousDir
+		require.NoError(t, rules.Load())
+	}()
+	setConfig(t, func(c *config.Config) {
+		c.ApiRules.Apply = true
+	})
+
+	rules.Dir = t.TempDir()
+	writeRule := func(name, content string) {
+		require.NoError(t, os.WriteFile(filepath.Join(rules.Dir, name), []byte(content), 0600))
+	}
+	writeRule("ChannelAccept.js", "true")
+	writeRule("HtlcForward.js", "HtlcForward.Event.OutgoingAmountMsat >= 1000")
+	require.NoError(t, rules.Load())
+
+	event := types.HtlcForwardEvent{Event: &routerrpc.ForwardHtlcInterceptRequest{OutgoingAmountMsat: 5000}}
+	accept, err := rules.Apply(event, make(chan bool, 1))
+	require.NoError(t, err)
+	require.True(t, accept)
+
+	// a broken script keeps the previous version
+	writeRule("HtlcForward.js", "HtlcForward.Event.(")
+	require.Error(t, rules.Load())
+	accept, err = rules.Apply(event, make(chan bool, 1))
+	require.NoError(t, err)
+	require.True(t, accept)
+
+	writeRule("HtlcForward.js", "HtlcForward.Event.OutgoingAmountMsat >= 10000")
+	require.NoError(t, rules.Load())
+	accept, err = rules.Apply(event, make(chan bool, 1))
+	require.NoError(t, err)
+	require.False(t, accept)
+}
+
+// rules use the config of the decision, not the current one
+func TestRules_ConfigSnapshot(t *testing.T) {
+	setConfig(t, func(c *config.Config) {
+		c.ApiRules.Apply = true
+	})
+	conf := config.Current().Copy()
+	conf.ApiRules.Apply = false
+
+	event := types.HtlcForwardEvent{Event: &routerrpc.ForwardHtlcInterceptRequest{}}
+	result, _, err := rules.EvaluateForward(context.Background(), conf, event)
+	require.NoError(t, err)
+	require.Equal(t, rules.NoDecision, result)
+}
+
+// every decision runs on a fresh runtime
+func TestRules_FreshRuntime(t *testing.T) {
+	previousDir := rules.Dir
+	defer func() {
+		rules.Dir = previousDir
+		require.NoError(t, rules.Load())
+	}()
+	setConfig(t, func(c *config.Config) {
+		c.ApiRules.Apply = true
+	})
+
+	rules.Dir = t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(rules.Dir, "ChannelAccept.js"), []byte("true"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(rules.Dir, "HtlcForward.js"), []byte(`
+const limit = 1000;
+if (typeof seen !== 'undefined') { false } else {
+	var seen = true;
+	HtlcForward.Event.OutgoingAmountMsat >= limit
+}`), 0600))
+	require.NoError(t, rules.Load())
+
+	event := types.HtlcForwardEvent{Event: &routerrpc.ForwardHtlcInterceptRequest{OutgoingAmountMsat: 5000}}
+	for i := 0; i < 2; i++ {
+		accept, err := rules.Apply(event, make(chan bool, 1))
+		require.NoError(t, err)
+		require.True(t, accept)
+	}
+}
+
+// globals that one decision sets are gone in the next one, although the
+// runtimes are reused
+func TestRules_PooledGlobals(t *testing.T) {
+	previousDir := rules.Dir
+	defer func() {
+		rules.Dir = previousDir
+		require.NoError(t, rules.Load())
+	}()
+	setConfig(t, func(c *config.Config) {
+		c.ApiRules.Apply = true
+	})
+
+	rules.Dir = t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(rules.Dir, "ChannelAccept.js"), []byte("true"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(rules.Dir, "HtlcForward.js"), []byte(`
+let fresh = typeof counter === 'undefined' && typeof previous === 'undefined' && typeof globalThis.helper === 'undefined';
+counter = 1;
+function helper() {}
+var previous = HtlcForward.Event;
+fresh // a comment on the last line`), 0600))
+	require.NoError(t, rules.Load())
+
+	event := types.HtlcForwardEvent{Event: &routerrpc.ForwardHtlcInterceptRequest{}}
+	for i := 0; i < 3; i++ {
+		result, err := rules.Evaluate(context.Background(), config.Current(), event)
+		require.NoError(t, err)
+		require.Equal(t, rules.Allow, result)
+	}
+}
+
+// environment variables and flags override the config file
+func TestConfigLoad_Overrides(t *testing.T) {
+	defer func() {
+		config.Overrides.LndDir = ""
+	}()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("host: \"127.0.0.1:10009\"\nforward-mode: \"denylist\"\n"), 0600))
+
+	t.Setenv("ELECTRONWALL_FORWARDMODE", "allowlist")
+	config.Overrides.LndDir = "/home/bitcoin/.lnd"
+
+	c, err := config.Load(path)
+	require.NoError(t, err)
+	require.Equal(t, "allowlist", c.ForwardMode)
+	require.Equal(t, "/home/bitcoin/.lnd/tls.cert", c.TLSPath)
+	require.Equal(t, "/home/bitcoin/.lnd/data/chain/bitcoin/mainnet/admin.macaroon", c.MacaroonPath)
+	require.Equal(t, filepath.Join(dir, "rules"), c.RulesDir)
+}
+
+// a relative rules-dir is relative to the directory of the config file, and
+// the flag to the working directory
+func TestConfigLoad_RulesDir(t *testing.T) {
+	defer func() {
+		config.Overrides.RulesDir = ""
+	}()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("host: \"127.0.0.1:10009\"\nlnddir: \"/home/bitcoin/.lnd\"\nrules-dir: \"scripts\"\n"), 0600))
+	c, err := config.Load(path)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "scripts"), c.RulesDir)
+
+	require.NoError(t, os.WriteFile(path, []byte("host: \"127.0.0.1:10009\"\nlnddir: \"/home/bitcoin/.lnd\"\nrules-dir: \"/etc/electronwall/rules\"\n"), 0600))
+	c, err = config.Load(path)
+	require.NoError(t, err)
+	require.Equal(t, "/etc/electronwall/rules", c.RulesDir)
+
+	config.Overrides.RulesDir = "flag-rules"
+	c, err = config.Load(path)
+	require.NoError(t, err)
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(wd, "flag-rules"), c.RulesDir)
+}
+
+// the macaroon path in lnddir depends on the network
+func TestConfigLoad_Network(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("host: \"127.0.0.1:10009\"\nlnddir: \"/home/bitcoin/.lnd\"\nnetwork: \"signet\"\n"), 0600))
+	c, err := config.Load(path)
+	require.NoError(t, err)
+	require.Equal(t, "/home/bitcoin/.lnd/data/chain/bitcoin/signet/admin.macaroon", c.MacaroonPath)
+
+	t.Setenv("ELECTRONWALL_NETWORK", "bitcoin")
+	_, err = config.Load(path)
+	require.ErrorContains(t, err, `invalid network "bitcoin"`)
+}
+
+func TestConfigLoad_MonitorSummaryInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("host: \"127.0.0.1:10009\"\nlnddir: \"/home/bitcoin/.lnd\"\n"), 0600))
+	c, err := config.Load(path)
+	require.NoError(t, err)
+	require.Equal(t, 600, c.MonitorSummaryInterval)
+
+	require.NoError(t, os.WriteFile(path, []byte("host: \"127.0.0.1:10009\"\nlnddir: \"/home/bitcoin/.lnd\"\nmonitor-summary-interval: -1\n"), 0600))
+	_, err = config.Load(path)
+	require.ErrorContains(t, err, "monitor summary interval must not be negative")
+}
+
+func TestConfigLoad_ReputationFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("host: \"127.0.0.1:10009\"\nlnddir: \"/home/bitcoin/.lnd\"\n"), 0600))
+	c, err := config.Load(path)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "reputation.json"), c.ForwardReputation.File)
+
+	require.NoError(t, os.WriteFile(path, []byte("host: \"127.0.0.1:10009\"\nlnddir: \"/home/bitcoin/.lnd\"\nforward-reputation:\n  file: \"/var/lib/electronwall/reputation.json\"\n"), 0600))
+	c, err = config.Load(path)
+	require.NoError(t, err)
+	require.Equal(t, "/var/lib/electronwall/reputation.json", c.ForwardReputation.File)
 }
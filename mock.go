@@ -3,12 +3,26 @@ package main
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/lightningnetwork/lnd/lnrpc"
 	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
+	"google.golang.org/protobuf/proto"
 )
 
 type lndclientMock struct {
+	// channels is what listChannels returns
+	channels []*lnrpc.Channel
+	// pendingChannels is what pendingOpenChannels returns
+	pendingChannels []*lnrpc.PendingChannelsResponse_PendingOpenChannel
+	// lookupDelay delays getPubKeyFromChannel regardless of the context
+	lookupDelay time.Duration
+	// infoErr makes getMyInfo fail without a response
+	infoErr error
+	// peers are the pubkeys of the peers by channel, other channels have
+	// the same peer
+	peers map[uint64]string
+
 	htlcEvents               chan *routerrpc.HtlcEvent
 	htlcInterceptorRequests  chan *routerrpc.ForwardHtlcInterceptRequest
 	htlcInterceptorResponses chan *routerrpc.ForwardHtlcInterceptResponse
@@ -49,7 +63,7 @@ func (lnd *lndclientMock) channelAcceptor(ctx context.Context) (
 
 func (c *channelAcceptorMock) RecvMsg(m interface{}) error {
 	req := <-c.channelAcceptorRequests
-	*m.(*lnrpc.ChannelAcceptRequest) = *req
+	proto.Merge(m.(*lnrpc.ChannelAcceptRequest), req)
 	return nil
 }
 
@@ -109,21 +123,38 @@ func (lnd *lndclientMock) getNodeAlias(ctx context.Context, pubkey string) (
 
 func (lnd *lndclientMock) getMyInfo(ctx context.Context) (
 	*lnrpc.GetInfoResponse, error) {
+	if lnd.infoErr != nil {
+		return nil, lnd.infoErr
+	}
 	info := &lnrpc.GetInfoResponse{
 		IdentityPubkey: "my-pubkey-is-very-long-for-trimming-pubkey",
 		Alias:          "my-alias",
+		BlockHeight:    800000,
 	}
 	return info, nil
 }
 
 func (lnd *lndclientMock) getPubKeyFromChannel(ctx context.Context, chan_id uint64) (
 	*lnrpc.ChannelEdge, error) {
+	time.Sleep(lnd.lookupDelay)
+	peer, ok := lnd.peers[chan_id]
+	if !ok {
+		peer = "other-pubkey-is-very-long-for-trimming-pubkey"
+	}
 	return &lnrpc.ChannelEdge{
 		Node1Pub: "my-pubkey-is-very-long-for-trimming-pubkey",
-		Node2Pub: "other-pubkey-is-very-long-for-trimming-pubkey",
+		Node2Pub: peer,
 	}, nil
 }
 
+func (lnd *lndclientMock) listChannels(ctx context.Context) ([]*lnrpc.Channel, error) {
+	return lnd.channels, nil
+}
+
+func (lnd *lndclientMock) pendingOpenChannels(ctx context.Context) ([]*lnrpc.PendingChannelsResponse_PendingOpenChannel, error) {
+	return lnd.pendingChannels, nil
+}
+
 // --------------- HTLC events mock ---------------
 
 type htlcEventsMock struct {
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// monitorStats counts the requests that monitor mode would have denied,
+// grouped by reason
+type monitorStats struct {
+	mu       sync.Mutex
+	channels map[string]int
+	forwards map[string]int
+}
+
+func newMonitorStats() *monitorStats {
+	return &monitorStats{
+		channels: map[string]int{},
+		forwards: map[string]int{},
+	}
+}
+
+func (m *monitorStats) recordChannel(reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.channels[reason]++
+}
+
+func (m *monitorStats) recordForward(reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.forwards[reason]++
+}
+
+// logSummaries periodically logs and resets the would-be rejections
+func (m *monitorStats) logSummaries(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.mu.Lock()
+			channels, forwards := m.channels, m.forwards
+			m.channels, m.forwards = map[string]int{}, map[string]int{}
+			m.mu.Unlock()
+
+			if len(channels) > 0 {
+				log.Infof("[monitor] Would have denied %d channel requests in the last %s: %s", sumCounts(channels), interval, formatCounts(channels))
+			}
+			if len(forwards) > 0 {
+				log.Infof("[monitor] Would have denied %d HTLCs in the last %s: %s", sumCounts(forwards), interval, formatCounts(forwards))
+			}
+		}
+	}
+}
+
+func sumCounts(counts map[string]int) int {
+	sum := 0
+	for _, n := range counts {
+		sum += n
+	}
+	return sum
+}
+
+// formatCounts lists the reasons, most frequent first
+func formatCounts(counts map[string]int) string {
+	reasons := make([]string, 0, len(counts))
+	for reason := range counts {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if counts[reasons[i]] != counts[reasons[j]] {
+			return counts[reasons[i]] > counts[reasons[j]]
+		}
+		return reasons[i] < reasons[j]
+	})
+	parts := make([]string, len(reasons))
+	for i, reason := range reasons {
+		parts[i] = fmt.Sprintf("%dx %s", counts[reason], reason)
+	}
+	return strings.Join(parts, ", ")
+}
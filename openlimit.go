@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/callebtc/electronwall/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// openLimiter limits the channel open requests per peer and from all peers
+// within a sliding window and temporarily denies peers that keep exceeding
+// their limit
+type openLimiter struct {
+	mu sync.Mutex
+	// peers and all are the times of the requests that were within the
+	// limits
+	peers map[string][]time.Time
+	all   []time.Time
+	// strikes are the times of the requests of a peer that exceeded its
+	// limit
+	strikes map[string][]time.Time
+	// denied are the peers that are denied until the time
+	denied map[string]time.Time
+	now    func() time.Time
+}
+
+func newOpenLimiter() *openLimiter {
+	return &openLimiter{
+		peers:   map[string][]time.Time{},
+		strikes: map[string][]time.Time{},
+		denied:  map[string]time.Time{},
+		now:     time.Now,
+	}
+}
+
+// since drops the times up to start
+func since(times []time.Time, start time.Time) []time.Time {
+	for i, t := range times {
+		if t.After(start) {
+			return times[i:]
+		}
+	}
+	return nil
+}
+
+// allow counts a channel open request of the peer with the hex encoded pubkey
+// if it is within the limits. Otherwise, the exceeded limit is returned.
+func (l *openLimiter) allow(rl config.ChannelRateLimitConfig, peer string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if until, ok := l.denied[peer]; ok {
+		if now.Before(until) {
+			return fmt.Sprintf("rate limit: denied for %ds", rl.DenylistDuration), false
+		}
+		delete(l.denied, peer)
+	}
+	if rl.PerPeer == 0 && rl.Global == 0 {
+		return "", true
+	}
+	start := now.Add(-time.Duration(rl.Window) * time.Second)
+	if len(l.peers) >= maxBuckets {
+		l.prune(start, now)
+	}
+	requests := since(l.peers[peer], start)
+	l.all = since(l.all, start)
+	if rl.PerPeer > 0 && len(requests) >= rl.PerPeer {
+		l.peers[peer] = requests
+		reason := fmt.Sprintf("rate limit: more than %d requests from the peer in %ds", rl.PerPeer, rl.Window)
+		if rl.DenylistAfter == 0 {
+			return reason, false
+		}
+		strikes := append(since(l.strikes[peer], start), now)
+		if len(strikes) < rl.DenylistAfter {
+			l.strikes[peer] = strikes
+			return reason, false
+		}
+		delete(l.strikes, peer)
+		until := now.Add(time.Duration(rl.DenylistDuration) * time.Second)
+		l.denied[peer] = until
+		log.Infof("[channel] Denying %s until %s after %d rate limited requests", peer, until.Format(time.RFC3339), len(strikes))
+		return fmt.Sprintf("rate limit: denied for %ds", rl.DenylistDuration), false
+	}
+	if rl.Global > 0 && len(l.all) >= rl.Global {
+		l.peers[peer] = requests
+		return fmt.Sprintf("rate limit: more than %d requests in %ds", rl.Global, rl.Window), false
+	}
+	l.peers[peer] = append(requests, now)
+	l.all = append(l.all, now)
+	return "", true
+}
+
+// prune drops the peers without requests since start and the denials that
+// expired by now. Must be called with mu held.
+func (l *openLimiter) prune(start, now time.Time) {
+	for peer, requests := range l.peers {
+		if len(since(requests, start)) == 0 {
+			delete(l.peers, peer)
+		}
+	}
+	for peer, strikes := range l.strikes {
+		if len(since(strikes, start)) == 0 {
+			delete(l.strikes, peer)
+		}
+	}
+	for peer, until := range l.denied {
+		if !now.Before(until) {
+			delete(l.denied, peer)
+		}
+	}
+}
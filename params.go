@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/callebtc/electronwall/config"
+	"github.com/callebtc/electronwall/types"
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+// channelParams returns the parameters of an accepted channel: the values of
+// the request with the channel-accept-params of the peer applied
+func channelParams(conf *config.Config, req *lnrpc.ChannelAcceptRequest) *types.ChannelAcceptParams {
+	tier, pc := conf.ChannelParams(hex.EncodeToString(req.NodePubkey))
+	params := &types.ChannelAcceptParams{
+		Tier:            tier,
+		CsvDelay:        req.CsvDelay,
+		MaxHtlcCount:    req.MaxAcceptedHtlcs,
+		ReserveSat:      req.ChannelReserve,
+		InFlightMaxMsat: req.MaxValueInFlight,
+		MinHtlcIn:       req.MinHtlc,
+		UpfrontShutdown: pc.UpfrontShutdown,
+	}
+	if pc.CsvDelay != nil {
+		params.CsvDelay = *pc.CsvDelay
+	}
+	if pc.MaxHtlcCount != nil {
+		params.MaxHtlcCount = *pc.MaxHtlcCount
+	}
+	if pc.ReserveSat != nil {
+		params.ReserveSat = *pc.ReserveSat
+	}
+	if pc.InFlightMaxMsat != nil {
+		params.InFlightMaxMsat = *pc.InFlightMaxMsat
+	}
+	if pc.MinHtlcIn != nil {
+		params.MinHtlcIn = *pc.MinHtlcIn
+	}
+	return params
+}
+
+// validateChannelParams replaces the parameters that are out of bounds for
+// the request with the values of the request and returns why. An invalid
+// upfront shutdown address on the network is dropped. A zero value lets LND
+// choose its default.
+func validateChannelParams(params *types.ChannelAcceptParams, req *lnrpc.ChannelAcceptRequest, network string) []string {
+	var invalid []string
+	fundingMsat := req.FundingAmt * 1000
+	if params.CsvDelay > config.MaxCsvDelay {
+		invalid = append(invalid, fmt.Sprintf("csv delay %d above %d blocks", params.CsvDelay, config.MaxCsvDelay))
+		params.CsvDelay = req.CsvDelay
+	}
+	if params.MaxHtlcCount > config.MaxHtlcSlots {
+		invalid = append(invalid, fmt.Sprintf("max HTLC count %d above %d", params.MaxHtlcCount, config.MaxHtlcSlots))
+		params.MaxHtlcCount = req.MaxAcceptedHtlcs
+	}
+	// BOLT 2 requires a reserve of at least the dust limit of the opener
+	if params.ReserveSat != 0 && (params.ReserveSat < req.DustLimit || params.ReserveSat >= req.FundingAmt) {
+		invalid = append(invalid, fmt.Sprintf("reserve %d sat not between the dust limit of %d sat and the funding of %d sat", params.ReserveSat, req.DustLimit, req.FundingAmt))
+		params.ReserveSat = req.ChannelReserve
+	}
+	if params.InFlightMaxMsat > fundingMsat {
+		invalid = append(invalid, fmt.Sprintf("max in-flight %d msat above the funding of %d msat", params.InFlightMaxMsat, fundingMsat))
+		params.InFlightMaxMsat = req.MaxValueInFlight
+	}
+	if fundingMsat > 0 && params.MinHtlcIn >= fundingMsat {
+		invalid = append(invalid, fmt.Sprintf("min HTLC %d msat not below the funding of %d msat", params.MinHtlcIn, fundingMsat))
+		params.MinHtlcIn = req.MinHtlc
+	}
+	if params.UpfrontShutdown != "" {
+		if err := config.CheckShutdownAddress(params.UpfrontShutdown, network); err != nil {
+			invalid = append(invalid, err.Error())
+			params.UpfrontShutdown = ""
+		}
+	}
+	return invalid
+}
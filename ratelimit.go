@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/callebtc/electronwall/config"
+	"github.com/callebtc/electronwall/types"
+)
+
+// maxBuckets is the number of token buckets above which full buckets are
+// dropped. A full bucket is the same as a new one.
+const maxBuckets = 10000
+
+// tokenBucket holds the HTLCs and msat that can currently be forwarded
+type tokenBucket struct {
+	htlcs   float64
+	msat    float64
+	updated time.Time
+}
+
+// rateLimiter keeps the token buckets of the forward rate limits
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		buckets: map[string]*tokenBucket{},
+		now:     time.Now,
+	}
+}
+
+// matchingLimit is a rate limit that applies to an HTLC with the key of its
+// bucket
+type matchingLimit struct {
+	index int
+	limit config.RateLimit
+	key   string
+}
+
+func matchingLimits(conf *config.Config, in, out uint64, peer string) []matchingLimit {
+	var limits []matchingLimit
+	for i, l := range conf.RateLimits {
+		subject, ok := l.Subject(in, out, peer)
+		if !ok {
+			continue
+		}
+		// a changed limit starts with a new bucket
+		limits = append(limits, matchingLimit{index: i, limit: l, key: l.String() + "|" + subject})
+	}
+	return limits
+}
+
+// bucket returns the refilled bucket for the limit. Must be called with mu held.
+func (r *rateLimiter) bucket(m matchingLimit) *tokenBucket {
+	now := r.now()
+	b, ok := r.buckets[m.key]
+	if !ok {
+		if len(r.buckets) >= maxBuckets {
+			r.prune(now)
+		}
+		b = &tokenBucket{htlcs: m.limit.HtlcBurst, msat: m.limit.MsatPerMinute, updated: now}
+		r.buckets[m.key] = b
+		return b
+	}
+	elapsed := now.Sub(b.updated).Seconds()
+	b.htlcs = math.Min(m.limit.HtlcBurst, b.htlcs+elapsed*m.limit.HtlcsPerSecond)
+	b.msat = math.Min(m.limit.MsatPerMinute, b.msat+elapsed*m.limit.MsatPerMinute/60)
+	b.updated = now
+	return b
+}
+
+// prune drops the buckets that have not been used for a minute, by which time
+// every bucket is full again. Must be called with mu held.
+func (r *rateLimiter) prune(now time.Time) {
+	for key, b := range r.buckets {
+		if now.Sub(b.updated) > time.Minute {
+			delete(r.buckets, key)
+		}
+	}
+}
+
+// levels returns the current levels of all rate limits that apply to an HTLC
+func (r *rateLimiter) levels(conf *config.Config, in, out uint64, peer string) []types.RateLimitLevel {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var levels []types.RateLimitLevel
+	for _, m := range matchingLimits(conf, in, out, peer) {
+		b := r.bucket(m)
+		level := types.RateLimitLevel{Limit: m.limit.String(), Htlcs: -1, Msat: -1}
+		if m.limit.HtlcsPerSecond > 0 {
+			level.Htlcs = b.htlcs
+		}
+		if m.limit.MsatPerMinute > 0 {
+			level.Msat = b.msat
+		}
+		levels = append(levels, level)
+	}
+	return levels
+}
+
+// take takes an HTLC of amtMsat from all buckets that apply to it. If any of
+// them is exhausted, nothing is taken and the exhausted limit is returned.
+func (r *rateLimiter) take(conf *config.Config, in, out uint64, peer string, amtMsat uint64) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	limits := matchingLimits(conf, in, out, peer)
+	buckets := make([]*tokenBucket, len(limits))
+	for i, m := range limits {
+		b := r.bucket(m)
+		if m.limit.HtlcsPerSecond > 0 && b.htlcs < 1 {
+			return fmt.Sprintf("rate limit %d: %s", m.index+1, m.limit), false
+		}
+		if m.limit.MsatPerMinute > 0 && b.msat < float64(amtMsat) {
+			return fmt.Sprintf("rate limit %d: %s", m.index+1, m.limit), false
+		}
+		buckets[i] = b
+	}
+	for i, m := range limits {
+		if m.limit.HtlcsPerSecond > 0 {
+			buckets[i].htlcs--
+		}
+		if m.limit.MsatPerMinute > 0 {
+			buckets[i].msat -= float64(amtMsat)
+		}
+	}
+	return "", true
+}
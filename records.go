@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/callebtc/electronwall/config"
+	"github.com/callebtc/electronwall/types"
+)
+
+// known custom record types
+const (
+	recordKeysendPreimage = 5482373484
+	recordMessage         = 34349334
+	recordSenderPubkey    = 34349339
+	recordBoostagram      = 7629169
+	recordTipNote         = 7629171
+)
+
+// decodeCustomRecords decodes the known custom records of an HTLC and
+// encodes all records as hex
+func decodeCustomRecords(records map[uint64][]byte) types.CustomRecords {
+	var decoded types.CustomRecords
+	if len(records) == 0 {
+		return decoded
+	}
+	decoded.Records = make(map[string]string, len(records))
+	for recordType, value := range records {
+		decoded.Records[strconv.FormatUint(recordType, 10)] = hex.EncodeToString(value)
+		decoded.Size += len(value)
+	}
+	_, decoded.KeysendPreimage = records[recordKeysendPreimage]
+	if pubkey := records[recordSenderPubkey]; len(pubkey) == 33 {
+		decoded.SenderPubkey = hex.EncodeToString(pubkey)
+	}
+	decoded.Message = recordText(records[recordMessage])
+	decoded.TipNote = recordText(records[recordTipNote])
+	if boostagram, ok := records[recordBoostagram]; ok {
+		if err := json.Unmarshal(boostagram, &decoded.Boostagram); err != nil {
+			decoded.Boostagram = nil
+		}
+	}
+	return decoded
+}
+
+// recordText returns a record as a string if it is valid UTF-8
+func recordText(value []byte) string {
+	if !utf8.Valid(value) {
+		return ""
+	}
+	return string(value)
+}
+
+// checkCustomRecords returns whether the custom records of a forward are
+// allowed and which record is not if they are not. Records are checked in
+// the order of their type.
+func checkCustomRecords(conf *config.Config, records map[uint64][]byte) (string, bool) {
+	recordTypes := make([]uint64, 0, len(records))
+	size := 0
+	for recordType, value := range records {
+		recordTypes = append(recordTypes, recordType)
+		size += len(value)
+	}
+	sort.Slice(recordTypes, func(i, j int) bool { return recordTypes[i] < recordTypes[j] })
+	for _, recordType := range recordTypes {
+		if reason, ok := conf.ForwardCustomRecords.CheckRecord(recordType, len(records[recordType])); !ok {
+			return "custom records: " + reason, false
+		}
+	}
+	if conf.ForwardCustomRecords.MaxTotalSize > 0 && size > conf.ForwardCustomRecords.MaxTotalSize {
+		return fmt.Sprintf("custom records: %d bytes, more than %d", size, conf.ForwardCustomRecords.MaxTotalSize), false
+	}
+	return "", true
+}
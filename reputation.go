@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/callebtc/electronwall/config"
+	"github.com/callebtc/electronwall/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// htlcOutcome is how a forwarded HTLC was resolved
+type htlcOutcome int
+
+const (
+	outcomeSettled htlcOutcome = iota
+	outcomeFailed
+	outcomeLinkFailed
+)
+
+// peerReputation holds the outcomes of the HTLCs of a peer. Every count
+// decays with the configured half-life.
+type peerReputation struct {
+	Settled      float64   `json:"settled"`
+	Failed       float64   `json:"failed"`
+	LinkFailures float64   `json:"link_failures"`
+	Slow         float64   `json:"slow"`
+	HoldSeconds  float64   `json:"hold_seconds"`
+	Updated      time.Time `json:"updated"`
+}
+
+// decay ages the counts to now
+func (p *peerReputation) decay(now time.Time, halfLife time.Duration) {
+	if !p.Updated.IsZero() && now.After(p.Updated) {
+		f := math.Pow(0.5, float64(now.Sub(p.Updated))/float64(halfLife))
+		p.Settled *= f
+		p.Failed *= f
+		p.LinkFailures *= f
+		p.Slow *= f
+		p.HoldSeconds *= f
+	}
+	p.Updated = now
+}
+
+// score is the share of settled HTLCs, where slow HTLCs count as failures
+// and link failures count twice. A peer without history scores 0.5.
+func (p peerReputation) score() float64 {
+	return (p.Settled + 1) / (p.Settled + p.Failed + p.Slow + 2*p.LinkFailures + 2)
+}
+
+// reputationStore scores incoming peers by the outcome of their HTLCs
+type reputationStore struct {
+	mu    sync.Mutex
+	peers map[string]*peerReputation
+	now   func() time.Time
+}
+
+func newReputationStore() *reputationStore {
+	return &reputationStore{
+		peers: map[string]*peerReputation{},
+		now:   time.Now,
+	}
+}
+
+func halfLife(conf *config.Config) time.Duration {
+	return time.Duration(conf.ForwardReputation.HalfLifeHours * float64(time.Hour))
+}
+
+// record adds the outcome of an HTLC of peer that was held for hold
+func (r *reputationStore) record(conf *config.Config, peer string, outcome htlcOutcome, hold time.Duration) {
+	if peer == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p := r.peers[peer]
+	if p == nil {
+		p = &peerReputation{}
+		r.peers[peer] = p
+	}
+	p.decay(r.now(), halfLife(conf))
+	switch outcome {
+	case outcomeSettled:
+		p.Settled++
+	case outcomeFailed:
+		p.Failed++
+	case outcomeLinkFailed:
+		p.LinkFailures++
+	}
+	if hold.Seconds() > conf.ForwardReputation.SlowHoldSeconds {
+		p.Slow++
+	}
+	p.HoldSeconds += hold.Seconds()
+}
+
+// get returns the reputation of peer
+func (r *reputationStore) get(conf *config.Config, peer string) types.Reputation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var p peerReputation
+	if stored := r.peers[peer]; stored != nil {
+		stored.decay(r.now(), halfLife(conf))
+		p = *stored
+	}
+	return reputationOf(p)
+}
+
+func reputationOf(p peerReputation) types.Reputation {
+	rep := types.Reputation{
+		Score:        p.score(),
+		Settled:      p.Settled,
+		Failed:       p.Failed,
+		LinkFailures: p.LinkFailures,
+		Slow:         p.Slow,
+	}
+	if resolved := p.Settled + p.Failed + p.LinkFailures; resolved > 0 {
+		rep.AvgHoldSeconds = p.HoldSeconds / resolved
+	}
+	return rep
+}
+
+// load reads the reputations from path. A missing file is an empty store.
+func (r *reputationStore) load(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	peers := map[string]*peerReputation{}
+	if err := json.Unmarshal(data, &peers); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.peers = peers
+	return nil
+}
+
+// save writes the reputations to path
+func (r *reputationStore) save(path string) error {
+	r.mu.Lock()
+	data, err := json.MarshalIndent(r.peers, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	// replace the file at once so that it is never half written
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// persist saves the reputations to path every interval until ctx is done
+// and once more when it is
+func (r *reputationStore) persist(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := r.save(path); err != nil {
+				log.Errorf("[reputation] Could not save %s: %v", path, err)
+			}
+			return
+		case <-ticker.C:
+			if err := r.save(path); err != nil {
+				log.Errorf("[reputation] Could not save %s: %v", path, err)
+			}
+		}
+	}
+}
+
+// printReputation prints the saved reputation of all peers, lowest score
+// first
+func printReputation(configPath string) error {
+	conf, err := config.Load(configPath)
+	if err != nil {
+		return err
+	}
+	store := newReputationStore()
+	if err := store.load(conf.ForwardReputation.File); err != nil {
+		return err
+	}
+	// age the counts to now
+	reps := make(map[string]types.Reputation, len(store.peers))
+	for peer := range store.peers {
+		reps[peer] = store.get(conf, peer)
+	}
+	peers := make([]string, 0, len(reps))
+	for peer := range reps {
+		peers = append(peers, peer)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return reps[peers[i]].Score < reps[peers[j]].Score
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PEER\tSCORE\tSETTLED\tFAILED\tLINK FAILURES\tSLOW\tAVG HOLD")
+	for _, peer := range peers {
+		rep := reps[peer]
+		fmt.Fprintf(w, "%s\t%.2f\t%.1f\t%.1f\t%.1f\t%.1f\t%.1fs\n",
+			peer, rep.Score, rep.Settled, rep.Failed, rep.LinkFailures, rep.Slow, rep.AvgHoldSeconds)
+	}
+	return w.Flush()
+}
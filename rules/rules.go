@@ -1,52 +1,240 @@
 package rules
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/callebtc/electronwall/config"
 	"github.com/callebtc/electronwall/types"
 	"github.com/dop251/goja"
+	"github.com/lightningnetwork/lnd/lnrpc"
 	log "github.com/sirupsen/logrus"
 )
 
+// Dir is the directory the rule scripts are loaded from
+var Dir = "rules"
+
+// script is a rule file that is compiled once and recompiled when it changes
+type script struct {
+	name    string
+	mu      sync.RWMutex
+	program *goja.Program
+}
+
+var (
+	htlcForwardScript   = &script{name: "HtlcForward.js"}
+	channelAcceptScript = &script{name: "ChannelAccept.js"}
+)
+
+// vmPool holds runtimes that are reused across decisions. A runtime is only
+// ever used by one goroutine at a time and is reset before it is put back.
+var vmPool = sync.Pool{
+	New: func() interface{} {
+		return goja.New()
+	},
+}
+
+// reset removes the globals that a decision left behind. Globals declared
+// with var cannot be deleted and are set to undefined instead, which is what
+// a fresh runtime sees before the declaration runs.
+func reset(vm *goja.Runtime) {
+	vm.ClearInterrupt()
+	global := vm.GlobalObject()
+	for _, key := range global.Keys() {
+		if global.Delete(key) != nil {
+			global.Set(key, goja.Undefined())
+		}
+	}
+}
+
+func (s *script) path() string {
+	return filepath.Join(Dir, s.name)
+}
+
+// compile reads and compiles the script. On error, the previously compiled
+// program is kept. The script runs in a block, so that its top-level let and
+// const declarations do not outlive a decision on a reused runtime.
+func (s *script) compile() error {
+	js_script, err := os.ReadFile(s.path())
+	if err != nil {
+		return err
+	}
+	program, err := goja.Compile(s.name, "{"+string(js_script)+"\n}", false)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.program = program
+	s.mu.Unlock()
+	return nil
+}
+
+// get returns the compiled program and compiles it on first use
+func (s *script) get() (*goja.Program, error) {
+	s.mu.RLock()
+	program := s.program
+	s.mu.RUnlock()
+	if program != nil {
+		return program, nil
+	}
+	if err := s.compile(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.program, nil
+}
+
+// Load compiles all rule scripts
+func Load() error {
+	for _, s := range []*script{htlcForwardScript, channelAcceptScript} {
+		if err := s.compile(); err != nil {
+			return fmt.Errorf("could not load %s: %w", s.path(), err)
+		}
+	}
+	return nil
+}
+
+// Watch recompiles the rule scripts whenever they change on disk
+func Watch(ctx context.Context) {
+	for _, s := range []*script{htlcForwardScript, channelAcceptScript} {
+		s := s
+		go config.WatchFile(ctx, s.path(), func() {
+			if err := s.compile(); err != nil {
+				log.Errorf("[rules] Keeping previous version of %s: %v", s.path(), err)
+				return
+			}
+			log.Infof("[rules] Reloaded %s", s.path())
+		})
+	}
+}
+
+// Result is the outcome of a rule script
+type Result int
+
+const (
+	// NoDecision means the rules are disabled or the script returned
+	// undefined or null
+	NoDecision Result = iota
+	Deny
+	Allow
+)
+
+func (r Result) String() string {
+	switch r {
+	case Deny:
+		return "deny"
+	case Allow:
+		return "allow"
+	}
+	return "none"
+}
+
+// Apply runs the rule script for the event with the current config and
+// returns whether it accepts the event. A script without a decision accepts.
 func Apply(s interface{}, decision_chan chan bool) (accept bool, err error) {
+	result, err := Evaluate(context.Background(), config.Current(), s)
+	if err != nil {
+		return false, err
+	}
+	accept = result != Deny
+	decision_chan <- accept
+	return accept, nil
+}
+
+// Evaluate runs the rule script for the event with the config that the
+// decision uses. The script is interrupted when ctx is done.
+func Evaluate(ctx context.Context, conf *config.Config, s interface{}) (Result, error) {
+	result, failure, err := evaluate(ctx, conf, s)
+	if err == nil && failure != "" {
+		return NoDecision, fmt.Errorf("rule returned %q, expected a boolean", failure)
+	}
+	return result, err
+}
+
+// EvaluateForward runs the HtlcForward rule for the event. Besides a boolean,
+// the rule can return the name of a failure code like
+// "TEMPORARY_CHANNEL_FAILURE" to deny the HTLC with that code.
+func EvaluateForward(ctx context.Context, conf *config.Config, event types.HtlcForwardEvent) (Result, lnrpc.Failure_FailureCode, error) {
+	result, failure, err := evaluate(ctx, conf, event)
+	if err != nil || failure == "" {
+		return result, 0, err
+	}
+	code, err := config.ParseFailureCode(failure)
+	if err != nil {
+		return NoDecision, 0, fmt.Errorf("rule returned %q: %w", failure, err)
+	}
+	return Deny, code, nil
+}
+
+// evaluate runs the rule script for the event and returns the returned
+// string, if any, as the failure
+func evaluate(ctx context.Context, conf *config.Config, s interface{}) (result Result, failure string, err error) {
 
-	if !config.Configuration.ApiRules.Apply {
-		return true, nil
+	if !conf.ApiRules.Apply {
+		return NoDecision, "", nil
 	}
 
-	vm := goja.New()
+	start := time.Now()
+
+	vm := vmPool.Get().(*goja.Runtime)
+	stop := context.AfterFunc(ctx, func() {
+		vm.Interrupt(ctx.Err())
+	})
+	defer func() {
+		// a runtime that may still get interrupted is not reused
+		if stop() {
+			reset(vm)
+			vmPool.Put(vm)
+		}
+	}()
 
-	var js_script []byte
+	var program *goja.Program
 
 	// load script according to event type
 	switch s.(type) {
 	case types.HtlcForwardEvent:
 		vm.Set("HtlcForward", s)
-		js_script, err = os.ReadFile("rules/HtlcForward.js")
-		if err != nil {
-			log.Fatal(err)
-		}
+		program, err = htlcForwardScript.get()
 	case types.ChannelAcceptEvent:
 		vm.Set("ChannelAccept", s)
-		js_script, err = os.ReadFile("rules/ChannelAccept.js")
-		if err != nil {
-			log.Fatal(err)
-		}
+		program, err = channelAcceptScript.get()
 	default:
-		return false, fmt.Errorf("no rule found for event type")
+		return NoDecision, "", fmt.Errorf("no rule found for event type")
+	}
+	if err != nil {
+		log.Errorf("JS error: %v", err)
+		return
 	}
 
 	// execute script
-	v, err := vm.RunString(string(js_script))
+	v, err := vm.RunProgram(program)
 	if err != nil {
 		log.Errorf("JS error: %v", err)
 		return
 	}
 
-	accept = v.Export().(bool)
-	decision_chan <- accept
-	log.Infof("[rules] decision: %t", accept)
-	return accept, nil
+	switch exported := v.Export().(type) {
+	case nil:
+		result = NoDecision
+	case bool:
+		result = Deny
+		if exported {
+			result = Allow
+		}
+	case string:
+		result, failure = Deny, exported
+	default:
+		return NoDecision, "", fmt.Errorf("rule returned %v, expected a boolean", v)
+	}
+	if failure != "" {
+		log.Infof("[rules] decision: %s %s (%s)", result, failure, time.Since(start))
+	} else {
+		log.Infof("[rules] decision: %s (%s)", result, time.Since(start))
+	}
+	return result, failure, nil
 }
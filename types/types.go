@@ -1,6 +1,8 @@
 package types
 
 import (
+	"time"
+
 	"github.com/callebtc/electronwall/api"
 	"github.com/lightningnetwork/lnd/lnrpc"
 	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
@@ -12,6 +14,105 @@ type HtlcForwardEvent struct {
 	PubkeyTo   string
 	AliasTo    string
 	Event      *routerrpc.ForwardHtlcInterceptRequest
+	// RateLimits are the levels of the rate limits that apply to the HTLC
+	RateLimits []RateLimitLevel
+	// Reputation is the reputation of the incoming peer
+	Reputation Reputation
+	// FeeMsat is the fee the HTLC pays and FeePpm its rate in ppm of the
+	// outgoing amount
+	FeeMsat int64
+	FeePpm  int64
+	// CltvDelta is the incoming minus the outgoing expiry. The blocks until
+	// the expiries are 0 if the block height is not known yet.
+	BlockHeight               uint32
+	CltvDelta                 int64
+	BlocksUntilIncomingExpiry int64
+	BlocksUntilOutgoingExpiry int64
+	// IncomingChannel and OutgoingChannel are snapshots of the channels of
+	// the forward, or nil if they are not known
+	IncomingChannel *ChannelSnapshot
+	OutgoingChannel *ChannelSnapshot
+	// CustomRecords are the decoded TLV custom records of the HTLC
+	CustomRecords CustomRecords
+}
+
+// CustomRecords are the TLV custom records of an HTLC. Known record types are
+// decoded into fields, and Records holds all records as hex strings by their
+// decimal type.
+type CustomRecords struct {
+	KeysendPreimage bool
+	SenderPubkey    string
+	Message         string
+	// Boostagram is the podcasting 2.0 JSON record, or nil if there is none
+	// or it is not valid JSON
+	Boostagram map[string]interface{}
+	TipNote    string
+	Records    map[string]string
+	// Size is the total size of the record values in bytes
+	Size int
+}
+
+// ForwardLifecycle links an intercepted HTLC to the decision on it and to its
+// outcome. Decision is allow, monitor, deny, fallback or overload. Resolution
+// is settle, forward_fail or link_fail for resumed HTLCs and fail for HTLCs
+// that electronwall failed. Hold is the time from the interception to the
+// resolution.
+type ForwardLifecycle struct {
+	IncomingChanId     uint64
+	IncomingHtlcId     uint64
+	OutgoingChanId     uint64
+	PubkeyFrom         string
+	AliasFrom          string
+	PubkeyTo           string
+	AliasTo            string
+	IncomingAmountMsat uint64
+	OutgoingAmountMsat uint64
+	FeeMsat            int64
+	Decision           string
+	Reason             string
+	FailureCode        string
+	Resolution         string
+	FailureString      string
+	// EarnedFeeMsat is FeeMsat if the HTLC settled and 0 otherwise
+	EarnedFeeMsat int64
+	Intercepted   time.Time
+	Hold          time.Duration
+}
+
+// ChannelSnapshot is the state of a channel from ListChannels. Amounts are in
+// sat.
+type ChannelSnapshot struct {
+	ChanId        uint64
+	RemotePubkey  string
+	Capacity      int64
+	LocalBalance  int64
+	RemoteBalance int64
+	PendingHtlcs  int
+	// MaxOutgoingHtlcs and MaxIncomingHtlcs are the negotiated numbers of
+	// HTLCs that the channel carries at once to and from the peer
+	MaxOutgoingHtlcs uint32
+	MaxIncomingHtlcs uint32
+	Active           bool
+	Private          bool
+}
+
+// Reputation scores a peer by the outcome of its HTLCs. The counts decay
+// over time. Score is between 0 and 1 and 0.5 for a peer without history.
+type Reputation struct {
+	Score          float64
+	Settled        float64
+	Failed         float64
+	LinkFailures   float64
+	Slow           float64
+	AvgHoldSeconds float64
+}
+
+// RateLimitLevel is what is left of a rate limit before the HTLC is forwarded.
+// Htlcs and Msat are -1 if the limit does not limit them.
+type RateLimitLevel struct {
+	Limit string
+	Htlcs float64
+	Msat  float64
 }
 
 type ChannelAcceptEvent struct {
@@ -21,4 +122,38 @@ type ChannelAcceptEvent struct {
 	NodeInfo   *lnrpc.NodeInfo
 	OneMl      api.OneML_NodeInfoResponse
 	Amboss     api.Amboss_NodeInfoResponse
+	// CommitmentType is the name of the commitment type of the request, like
+	// ANCHORS, and WantsZeroConf whether the peer asks for a zero-conf
+	// channel
+	CommitmentType string
+	WantsZeroConf  bool
+	// Params are sent back if the channel is accepted. The rule can change
+	// them.
+	Params *ChannelAcceptParams
+	// Counts are the channels of the node before the request, or nil if
+	// they could not be listed
+	Counts *ChannelCounts
+}
+
+// ChannelCounts are the open and pending channels with the peer of a channel
+// open request, their capacity in sat, and the pending channels that any
+// peers opened to the node
+type ChannelCounts struct {
+	Open            int
+	Pending         int
+	OpenCapacity    int64
+	PendingCapacity int64
+	PendingInbound  int
+}
+
+// ChannelAcceptParams are the channel parameters of an accepted channel. Tier
+// is the name of the tier of channel-accept-params that applies to the peer.
+type ChannelAcceptParams struct {
+	Tier            string
+	CsvDelay        uint32
+	MaxHtlcCount    uint32
+	ReserveSat      uint64
+	InFlightMaxMsat uint64
+	MinHtlcIn       uint64
+	UpfrontShutdown string
 }